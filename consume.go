@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/spf13/pflag"
+	"github.com/viktorxia/chrjson-split/pkg/chrsplit"
+)
+
+// commitTimeout bounds the offset commit of the final flush, which runs after
+// the consumer was told to stop
+const commitTimeout = 30 * time.Second
+
+// runConsume implements `consume`: it reads the messages of a Kafka topic as
+// a consumer group member and appends each message value, one JSONL record,
+// to the output of its chromosome. The outputs are flushed to disk every
+// --flush-interval or --flush-messages messages, and the offsets are only
+// committed once the messages up to them are on disk, so a crash loses no
+// committed record (records flushed but not yet committed are delivered
+// again on restart).
+func runConsume(args []string) {
+	flags := pflag.NewFlagSet("consume", pflag.ExitOnError)
+	var (
+		brokers       = flags.StringSlice("brokers", nil, "Kafka bootstrap brokers, e.g. kafka1:9092,kafka2:9092 (required)")
+		topic         = flags.String("topic", "", "Topic to consume (required)")
+		group         = flags.String("group", "", "Consumer group ID; offsets are committed for this group (required)")
+		prefix        = flags.String("prefix", "output", "Output file prefix")
+		outputDir     = flags.String("output-dir", "", "Output directory, created if missing")
+		chrFieldName  = flags.String("chr-field-name", "chr", "Chromosome field name in JSON (a gjson path)")
+		chrNamesStr   = flags.StringP("chr-names", "c", "", "Custom chromosome names (comma-separated)")
+		normalize     = flags.Bool("normalize", false, "Match chromosomes ignoring a chr prefix and case")
+		dynamic       = flags.Bool("dynamic", false, "Create an output for every distinct value of the field instead of a fixed chromosome list")
+		gzipOutput    = flags.Bool("gzip", false, "Write gzip-compressed output files (.jsonl.gz)")
+		flushInterval = flags.Duration("flush-interval", 5*time.Second, "Flush the outputs and commit offsets this often")
+		flushMessages = flags.Int("flush-messages", 10000, "Also flush and commit after this many messages (0 disables)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Split the messages of a Kafka topic into per-chromosome JSONL files, continuously\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s consume --brokers HOST:PORT --topic TOPIC --group GROUP [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s consume --brokers kafka:9092 --topic variants --group splitter --output-dir split/\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s consume --brokers kafka:9092 --topic variants --group splitter --flush-interval 1s --gzip\n", os.Args[0])
+	}
+	flags.Parse(args)
+
+	if len(*brokers) == 0 || *topic == "" || *group == "" {
+		fmt.Fprintf(os.Stderr, "Error: --brokers, --topic and --group are required\n\n")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *flushInterval <= 0 {
+		log.Fatalf("Error: --flush-interval must be positive")
+	}
+	if *flushMessages < 0 {
+		log.Fatalf("Error: --flush-messages must not be negative")
+	}
+
+	opts := chrsplit.Options{
+		Gzip:              *gzipOutput,
+		Normalize:         *normalize,
+		MitoAliases:       chrsplit.DefaultMitoAliases,
+		Dynamic:           *dynamic,
+		Workers:           1,
+		DecompressThreads: 1,
+		OutputDir:         *outputDir,
+		// the outputs grow across restarts of the consumer
+		Append: true,
+	}
+	processor := chrsplit.NewChromosomeProcessor(nil, *prefix, *chrFieldName, chrsplit.ParseChromosomeNames(*chrNamesStr), opts)
+	if err := processor.StartStream("topic " + *topic); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: *brokers,
+		Topic:   *topic,
+		GroupID: *group,
+	})
+	defer reader.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Consuming %s (group %s) from %v -> %s", *topic, *group, *brokers, processor.OutputPath("*"))
+
+	// messages are fetched on their own goroutine so the flush ticker keeps
+	// running while the topic is idle
+	messages := make(chan kafka.Message)
+	fetchErr := make(chan error, 1)
+	go func() {
+		for {
+			m, err := reader.FetchMessage(ctx)
+			if err != nil {
+				fetchErr <- err
+				return
+			}
+			select {
+			case messages <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		startTime = time.Now()
+		consumed  int
+		flushes   int
+		unflushed int
+		// the last message written per partition, committed by the next flush
+		pending = make(map[int]kafka.Message)
+	)
+	flush := func(ctx context.Context) error {
+		if unflushed == 0 {
+			return nil
+		}
+		if err := processor.SyncAllFiles(); err != nil {
+			return err
+		}
+		offsets := make([]kafka.Message, 0, len(pending))
+		for _, m := range pending {
+			offsets = append(offsets, m)
+		}
+		if err := reader.CommitMessages(ctx, offsets...); err != nil {
+			return fmt.Errorf("failed to commit offsets: %v", err)
+		}
+		clear(pending)
+		unflushed = 0
+		flushes++
+		return nil
+	}
+
+	ticker := time.NewTicker(*flushInterval)
+	defer ticker.Stop()
+
+	var runErr error
+loop:
+	for {
+		select {
+		case m := <-messages:
+			if err := processor.WriteRecord(m.Value); err != nil {
+				runErr = err
+				break loop
+			}
+			pending[m.Partition] = m
+			consumed++
+			unflushed++
+			if *flushMessages > 0 && unflushed >= *flushMessages {
+				if runErr = flush(ctx); runErr != nil {
+					break loop
+				}
+			}
+		case <-ticker.C:
+			if runErr = flush(ctx); runErr != nil {
+				break loop
+			}
+		case err := <-fetchErr:
+			if !errors.Is(err, context.Canceled) {
+				runErr = fmt.Errorf("failed to fetch from %s: %v", *topic, err)
+			}
+			break loop
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	stop()
+
+	// whatever was written is flushed and committed, also after an error: the
+	// message that failed was not written and is delivered again
+	commitCtx, cancel := context.WithTimeout(context.Background(), commitTimeout)
+	defer cancel()
+	if err := flush(commitCtx); err != nil && runErr == nil {
+		runErr = err
+	}
+	if err := processor.FinishStream(); err != nil && runErr == nil {
+		runErr = err
+	}
+
+	log.Printf("Consumed %d messages from %s in %.2f s (%d flushes)", consumed, *topic, time.Since(startTime).Seconds(), flushes)
+	stats := processor.Stats()
+	for _, chr := range processor.OutputChromosomes() {
+		log.Printf("  %s: %d", chr, stats[chr])
+	}
+	if runErr != nil {
+		log.Fatalf("Error: %v", runErr)
+	}
+}
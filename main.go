@@ -1,249 +1,839 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/pflag"
-	"github.com/tidwall/gjson"
+	"github.com/viktorxia/chrjson-split/pkg/chrsplit"
 )
 
-const UnknownChr = "unknown_chr"
-
-// ChromosomeProcessor is a processor for chromosome-specific JSONL files
-type ChromosomeProcessor struct {
-	inputFile     string
-	prefix        string
-	chrFieldName  string
-	chrNames      []string
-	chrSet        map[string]bool
-	outputWriters map[string]*bufio.Writer
-	outputFiles   map[string]*os.File
-}
-
-// NewChromosomeProcessor is the constructor for ChromosomeProcessor
-func NewChromosomeProcessor(inputFile, prefix, chrFieldName string, chrNames []string) *ChromosomeProcessor {
-	chrSet := make(map[string]bool)
-	for _, chr := range chrNames {
-		chrSet[chr] = true
-	}
+// exitInterrupted is the exit code of a run stopped by SIGINT or SIGTERM,
+// following the shell convention of 128 + SIGINT
+const exitInterrupted = 130
 
-	return &ChromosomeProcessor{
-		inputFile:     inputFile,
-		prefix:        prefix,
-		chrFieldName:  chrFieldName,
-		chrNames:      chrNames,
-		chrSet:        chrSet,
-		outputWriters: make(map[string]*bufio.Writer),
-		outputFiles:   make(map[string]*os.File),
-	}
-}
+// progressPoll is how often the line count is checked against
+// --progress-interval
+const progressPoll = 100 * time.Millisecond
 
-// InitializeOutputFiles creates output files for each chromosome
-func (cp *ChromosomeProcessor) InitializeOutputFiles() error {
+// infoLog carries the banners, progress and summary. It writes to stderr, so
+// stdout stays free for machine output such as --manifest -, and is silenced
+// by --quiet.
+var infoLog = log.New(os.Stderr, "", 0)
 
-	allChrs := append(cp.chrNames, UnknownChr)
+func main() {
 
-	for _, chr := range allChrs {
-		filename := fmt.Sprintf("%s_%s.jsonl", cp.prefix, chr)
+	if len(os.Args) > 1 && os.Args[1] == "watch" {
+		runWatch(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "consume" {
+		runConsume(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		runMerge(os.Args[2:])
+		return
+	}
 
-		file, err := os.Create(filename)
-		if err != nil {
-			cp.CloseAllFiles()
-			return fmt.Errorf("failed to create output file %s: %v", filename, err)
-		}
+	startTime := time.Now()
 
-		writer := bufio.NewWriterSize(file, 4*1024*1024)
+	// parse command line options
+	var (
+		inputFiles    = pflag.StringArrayP("input", "i", nil, "Input JSONL file path, optionally gzip/zstd/bzip2/xz-compressed ('-' for stdin, the default when piped); repeatable, positional arguments are inputs too")
+		prefix        = pflag.String("prefix", "output", "Output file prefix")
+		chrFieldName  = pflag.String("chr-field-name", "chr", "Chromosome field name in JSON; a gjson path, e.g. variant.location.chr (escape literal dots as \\.)")
+		chrFieldRaw   = pflag.Bool("chr-field-raw", false, "Treat --chr-field-name as one literal top-level key, not a path")
+		chrNamesStr   = pflag.StringP("chr-names", "c", "", "Custom chromosome names (comma-separated)")
+		dynamic       = pflag.Bool("dynamic", false, "Create an output for every distinct value of the field instead of a fixed chromosome list")
+		normalize     = pflag.Bool("normalize", false, "Match chromosomes ignoring a chr prefix and case (1, Chr1, CHR1 -> chr1)")
+		ignoreCase    = pflag.Bool("ignore-case", false, "Match chromosome names case-insensitively (ChrX, chrx -> chrX)")
+		mitoAliases   = pflag.StringSlice("mito-aliases", chrsplit.DefaultMitoAliases, "Names treated as the mitochondrial chromosome by --normalize")
+		inputComp     = pflag.String("input-compression", chrsplit.CompressionAuto, "Input compression: auto, gzip, zstd, bzip2, xz or none")
+		inputEncoding = pflag.String("input-encoding", chrsplit.EncodingAuto, "Input text encoding: auto (UTF-8, or UTF-16 with a BOM), utf-8, utf-16le or utf-16be")
+		format        = pflag.String("format", chrsplit.FormatJSONL, "Input record format: jsonl (one record per line), concat-json (back-to-back JSON objects), json-array (one top-level array), json-seq (RFC 7464, detected automatically), pretty (multi-line objects), vcf (split by CHROM, .vcf outputs), bed (BED/bedGraph, .bed outputs), gff (GFF3/GTF, .gff3 outputs), sam (split by RNAME, .sam outputs), tsv or csv (see --chr-column), or msgpack (consecutive MessagePack maps, .msgpack outputs)")
+		chrColumn     = pflag.String("chr-column", "", "With --format tsv or csv: name of the chromosome column in the header row")
+		chrColumnIdx  = pflag.Int("chr-column-index", 0, "With --format tsv or csv: chromosome column (from 1) of a file without a header row")
+		delimiter     = pflag.String("delimiter", "", "With --format tsv or csv: field delimiter instead of a tab or comma (one character, or \\t)")
+		recordDelim   = pflag.String("record-delimiter", "", "Byte ending every JSONL record, in the inputs and the outputs, instead of a newline: nul, rs (0x1e), or an escape like \\x00")
+		outputFormat  = pflag.String("output-format", chrsplit.FormatJSONL, "Output record format: jsonl, json-seq (RFC 7464, files named .json-seq) or tsv (the --fields columns, files named .tsv)")
+		keepFields    = pflag.StringSlice("keep-fields", nil, "Write only these fields (gjson paths) of each record, plus the chromosome field, e.g. chr,pos,ref,alt")
+		fields        = pflag.StringSlice("fields", nil, "Fields (gjson paths) written as columns by --output-format tsv, e.g. chr,pos,ref,alt")
+		inputList     = pflag.String("input-list", "", "Text file listing input paths, one per line ('#' starts a comment)")
+		recursive     = pflag.BoolP("recursive", "r", false, "Read all files matching --pattern below input directories")
+		patterns      = pflag.StringSlice("pattern", chrsplit.DefaultInputPatterns, "File name patterns picked up by --recursive")
+		memberPattern = pflag.StringSlice("member-pattern", chrsplit.DefaultInputPatterns, "Member name patterns processed from a tar (.tar, .tar.gz, .tgz, ...) or zip input")
+		workers       = pflag.IntP("workers", "w", 1, "Number of goroutines extracting chromosomes in parallel")
+		mmapInput     = pflag.Bool("mmap", false, "Memory-map uncompressed local inputs instead of reading them (other inputs are read as usual)")
+		decompThreads = pflag.Int("decompress-threads", runtime.NumCPU(), "Number of threads decompressing BGZF input")
+		maxOpenFiles  = pflag.Int("max-open-files", chrsplit.DefaultMaxOpenFiles, "Maximum number of output files kept open at once (least recently used ones are closed)")
+		follow        = pflag.Bool("follow", false, "Keep reading a growing input at its end, like tail -f, until interrupted")
+		idleTimeout   = pflag.Duration("idle-timeout", 0, "With --follow, stop after this long without new data (0 waits until interrupted)")
+		sentinel      = pflag.String("sentinel", "", "With --follow, stop when this exact line is read")
+		gzipOutput    = pflag.Bool("gzip", false, "Write gzip-compressed output files (.jsonl.gz); same as --compress gzip")
+		bgzipOutput   = pflag.Bool("bgzip", false, "Write BGZF (bgzip) output files that tabix can index (.gz); same as --compress bgzf")
+		compress      = pflag.String("compress", chrsplit.CompressionNone, "Output compression: gzip (.gz), bgzf (.gz, tabix-indexable), zstd (.zst) or none")
+		compressLevel = pflag.Int("compress-level", 0, "Output compression level: 1-9 for gzip and bgzf, 1-22 for zstd (0 uses the default)")
+		compThreads   = pflag.Int("compress-threads", 1, "Number of threads compressing the outputs, shared by all of them (gzip and zstd outputs are then written in independent 1MiB members or frames)")
+		strict        = pflag.Bool("strict", false, "Check that every line is valid JSON; invalid lines are dropped and reported")
+		writeMalform  = pflag.Bool("malformed-output", false, "With --strict, write invalid lines to <prefix>_malformed.jsonl")
+		validate      = pflag.Bool("validate", false, "Count lines that are not valid JSON and fail when there are too many of them")
+		maxInvalid    = pflag.Float64("max-invalid-fraction", 0.01, "With --validate, the largest tolerated fraction (0-1) of invalid lines")
+		commentPrefix = pflag.StringArray("comment-prefix", nil, "Skip lines starting with this prefix, e.g. '#' (repeatable)")
+		keepComments  = pflag.String("keep-comments", chrsplit.KeepCommentsNone, "With --comment-prefix: none, or header to copy the leading comment lines into every output")
+		headerPrefix  = pflag.String("replicate-header-prefix", "", "Copy the leading lines starting with this prefix (e.g. '##') to the top of every output")
+		filterField   = pflag.String("filter-field", "", "Only split records whose field (a gjson path) passes --filter-op against --filter-value")
+		filterOp      = pflag.String("filter-op", chrsplit.FilterEq, "Filter comparison: eq, ne, gt or lt (gt and lt compare numbers)")
+		filterValue   = pflag.String("filter-value", "", "Value the --filter-field is compared with")
+		binSize       = pflag.Int64("bin-size", 0, "Split every chromosome further into bins of this many positions (prefix_chr1_bin0000123.jsonl)")
+		posFieldName  = pflag.String("pos-field-name", "pos", "Position field name in JSON (a gjson path), used by --bin-size, --index, --merge-sorted and --sort-by-position")
+		sortByPos     = pflag.Bool("sort-by-position", false, "Sort the records of every output by --pos-field-name (stable; records without a position last), spilling sorted runs to temporary files")
+		sortBuffer    = pflag.String("sort-buffer", "64MB", "With --sort-by-position, records held in memory per output before a sorted run is spilled to disk")
+		dedup         = pflag.Bool("dedup", false, "Skip records identical to one already written to the same output (keeps a hash of every record in memory)")
+		dedupField    = pflag.String("dedup-field", "", "Deduplicate on this field (a gjson path, e.g. a variant ID) instead of the whole line; implies --dedup")
+		statsField    = pflag.String("stats-field", "", "Tally the values of this field (a gjson path, e.g. a variant type) per chromosome and print the cross-tab after the summary")
+		statsOut      = pflag.String("stats-out", "", "Write the --stats-field cross-tab as TSV to this path (- for stdout) instead of printing it")
+		mergeSorted   = pflag.Bool("merge-sorted", false, "Merge inputs that are each sorted by chromosome (natural order) and --pos-field-name, so every output is sorted too; an unsorted input is an error")
+		index         = pflag.Bool("index", false, "Write an index next to every output (prefix_chr1.jsonl.idx): its position range and the position and byte offset of every --index-every-th record")
+		indexEvery    = pflag.Int("index-every", 1000, "With --index, sample every Nth record of an output")
+		jsonHeader    = pflag.Int("json-header-lines", 0, "Treat the first N records as a header copied to the top of every output instead of routing them")
+		copyHeader    = pflag.Int("copy-header", 0, "Copy the first N lines verbatim to the top of every output; same as --json-header-lines N")
+		headerType    = pflag.String("header-type-field", "", "Treat leading records with FIELD=VALUE (e.g. type=header) as a header copied to the top of every output")
+		maxFileSize   = pflag.String("max-file-size", "", "Split each output into numbered parts of at most this size, e.g. 500MB or 2GiB (uncompressed)")
+		maxFileLines  = pflag.Int("max-lines-per-file", 0, "Split each output into numbered parts of at most this many lines")
+		maxRecord     = pflag.Int("max-record-bytes", 0, "Longest record (in bytes) routed as usual; 0 means no limit")
+		skipLines     = pflag.Int("skip-lines", 0, "Read and discard the first N lines of the inputs before routing")
+		maxLines      = pflag.Int("max-lines", 0, "Stop after routing this many lines, flushing and reporting as usual (0 means no limit)")
+		byteRange     = pflag.String("byte-range", "", "Only split the lines starting in this byte range of the input, START:END (END exclusive, e.g. 0:100GB), so instances given adjacent ranges share one input; needs --shard")
+		shard         = pflag.Int("shard", 0, "Add .shardNNN to the output names (prefix_chr1.shard003.jsonl), so instances never write the same files")
+		printSplits   = pflag.Int("print-splits", 0, "Print N balanced --byte-range and --shard arguments for the input and exit")
+		unknownName   = pflag.String("unknown-name", chrsplit.UnknownChr, "Name of the output for records whose chromosome is missing or not a target (prefix_NAME.jsonl)")
+		dropUnknown   = pflag.Bool("drop-unknown", false, "Count records whose chromosome is missing or not a target but do not write them (no unknown_chr file)")
+		noUnknown     = pflag.Bool("no-unknown", false, "Fail on the first record whose chromosome is missing or not a target, instead of writing it to unknown_chr")
+		sampleRate    = pflag.Float64("sample-rate", 0, "Keep each record with this probability (0-1), e.g. 0.01 for about 1%; reproducible with --seed")
+		perChrSample  = pflag.Int("per-chr-sample", 0, "Keep N records of every chromosome, chosen uniformly at random (reservoir sampling, held in memory); reproducible with --seed")
+		seed          = pflag.Int64("seed", 0, "Seed of --sample-rate and --per-chr-sample (0 picks a random seed, shown in the banner)")
+		sampleUnknown = pflag.Int("sample-unknown", 0, "Print the first N records routed to unknown_chr, with their chromosome value, to stderr")
+		oversize      = pflag.String("oversize-policy", chrsplit.OversizeError, "What to do with records over --max-record-bytes: error, skip or route-to-file (<prefix>_oversize.jsonl)")
+		force         = pflag.Bool("force", false, "Overwrite existing output files")
+		appendOutput  = pflag.Bool("append", false, "Append to existing output files instead of replacing them; an existing --manifest is updated with cumulative counts")
+		dryRun        = pflag.Bool("dry-run", false, "Only count lines per chromosome, do not write any output file")
+		countOnly     = pflag.Bool("count-only", false, "Only print the number of records per chromosome to stdout (tab-separated), counted on all CPUs unless --workers is given; nothing is written")
+		checkpointF   = pflag.String("checkpoint", "", "Record the progress of the run in FILE, with the outputs synced to disk, so an interrupted run can be resumed (local uncompressed inputs, one worker)")
+		ckptLines     = pflag.Int("checkpoint-lines", 1000000, "With --checkpoint, record the progress every N lines (0 disables)")
+		ckptInterval  = pflag.Duration("checkpoint-interval", time.Minute, "With --checkpoint, also record the progress this often (0 disables)")
+		resume        = pflag.Bool("resume", false, "Continue the run recorded by --checkpoint FILE instead of starting over")
+		httpTimeout   = pflag.Duration("http-timeout", 30*time.Second, "Timeout for connecting to an HTTP(S) input and receiving response headers")
+		httpRetries   = pflag.Int("http-retries", 5, "Number of retries (resuming with Range requests) for a failed HTTP(S) transfer")
+		gcsChunkSize  = pflag.Int64("gcs-read-chunk-size", chrsplit.DefaultGCSReadChunkSize, "Size in bytes of each ranged read of a gs:// input")
+		progressEvery = pflag.Int("progress-interval", 500000, "Print progress (with percent done and ETA for local files) to stderr every this many lines; 0 disables it")
+		quiet         = pflag.BoolP("quiet", "q", false, "Print errors only: no configuration banner, progress or summary")
+		verbose       = pflag.BoolP("verbose", "v", false, "Also log every input and output file opened, flushed and closed")
+		manifest      = pflag.String("manifest", "", "Write a JSON summary of inputs and outputs to this path")
+		nameTemplate  = pflag.String("filename-template", "", "Output file name with {prefix}, {chr}, {input} (input file name without extensions), {part} and {ext} (the usual extension) placeholders, e.g. '{chr}.split.jsonl' or '{chr}/calls{ext}'; a .gz, .bgz or .zst extension selects the output compression")
+		layout        = pflag.String("layout", "flat", "Output layout: flat (prefix_chr1.jsonl) or subdirs (chr=chr1/data.jsonl, with --filename-template naming the file in each directory)")
+		outputDir     = pflag.String("output-dir", ".", "Output directory, created (with any directories in --prefix) if missing")
+		help          = pflag.BoolP("help", "h", false, "Show help message")
+	)
 
-		cp.outputFiles[chr] = file
-		cp.outputWriters[chr] = writer
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "A tool to split a JSONL/NDJSON file by chromosome\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [input ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s watch --dir DIR [options]   (see %s watch --help)\n", os.Args[0], os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s consume --brokers HOST:PORT --topic TOPIC --group GROUP [options]   (see %s consume --help)\n", os.Args[0], os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s merge [options] file ...   (see %s merge --help)\n\n", os.Args[0], os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		pflag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s --input input.jsonl --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl.gz --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i run1.jsonl -i run2.jsonl --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --prefix output run1.jsonl run2.jsonl run3.jsonl\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i 'data/part-*.jsonl' --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i https://example.org/dump.jsonl.gz --prefix output --http-retries 10\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i s3://bucket/cohort/part-0001.jsonl.gz --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i gs://bucket/1kg/variants.jsonl --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --input-list shards.txt --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i ingest/ --recursive --pattern '*.jsonl.gz' --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i archive.tar.gz --member-pattern '*.jsonl' --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i samples.zip --member-pattern 'sample_*.jsonl' --workers 4 --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl.zst --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl.bz2 --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.dat --input-compression gzip --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i vendor.jsonl --input-encoding utf-16le --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i stream.json --format concat-json --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i variants.json --format json-array --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i export.json --format pretty --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i calls.vcf.gz --format vcf --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i peaks.bedGraph --format bed --strict --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i genes.gff3.gz --format gff --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  samtools view -h aln.bam | %s --format sam --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i regions.tsv --format tsv --chr-column chrom --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i records.msgpack --format msgpack --chr-field-name variant.chr --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i sites.txt --format csv --delimiter ';' --chr-column-index 2 --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i export.jsonl --record-delimiter nul --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i converted.jsonl --replicate-header-prefix '##' --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i export.jsonl --json-header-lines 1 --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i annotated.jsonl --comment-prefix '#' --comment-prefix // --keep-comments header\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i events.json-seq --output-format json-seq --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --filter-field FILTER --filter-value PASS --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --keep-fields pos,ref,alt --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --output-format tsv --fields chr,pos,ref,alt --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --gzip\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --compress zstd --compress-level 9\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i sorted.vcf --format vcf --prefix output --bgzip\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --force\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --progress-interval 5000000\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --quiet --manifest - > manifest.json\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i day2.jsonl --prefix output --append\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --output-dir split --prefix sample1\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i sample1.jsonl --output-dir split --filename-template '{input}.{chr}.jsonl.gz'\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i sample1.jsonl --output-dir split --layout subdirs --gzip\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --workers 8\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --mmap\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i annotated.jsonl --prefix output --follow --idle-timeout 10m\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --chr-field-name chrom --dry-run\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --count-only > counts.tsv\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --strict --malformed-output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --no-unknown\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --drop-unknown\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --unknown-name other\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --chr-field-name chrom --sample-unknown 5 --dry-run\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i huge.jsonl.gz --skip-lines 2000000 --max-lines 100000 -c chr1,chr2 --prefix debug\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i huge.jsonl --byte-range 0:100GB --shard 1 --prefix split\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i dump.jsonl --prefix output --validate --max-invalid-fraction 0.001\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --bin-size 1000000 --pos-field-name pos\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i sorted.jsonl --prefix output --index --index-every 10000\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --sort-by-position --sort-buffer 256MB --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i batch1.jsonl -i batch2.jsonl --merge-sorted --pos-field-name pos --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i merged.jsonl --dedup-field variant_id --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i variants.jsonl --stats-field type --stats-out types.tsv\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i huge.jsonl --sample-rate 0.01 --seed 42 --prefix testdata\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i huge.jsonl --per-chr-sample 1000 --seed 42 --prefix balanced\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --max-file-size 500MB\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --max-lines-per-file 1000000\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i sv.jsonl --prefix output --max-record-bytes 100000000 --oversize-policy route-to-file\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output --manifest output.manifest.json\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i huge.jsonl --prefix output --checkpoint split.ckpt [--resume]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  curl -s https://example.org/data.jsonl | %s -i - --prefix output\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i data.jsonl  --prefix result --chr-field-name chromosome\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i data.jsonl  --chr-field-name variant.location.chr\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i data.jsonl  --chr-field-name info.chrom --chr-field-raw\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i data.jsonl  --normalize --mito-aliases M,MT\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i data.jsonl  --ignore-case\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i events.jsonl --chr-field-name country --dynamic\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i assembly.jsonl -c \"$(paste -sd, scaffolds.txt)\" --max-open-files 500\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i data.jsonl  --chr-names \"chr1,chr2,chrX\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -i data.jsonl  -c \"chr1,chr2,chrX\" --prefix my_output\n", os.Args[0])
 	}
 
-	return nil
-}
+	pflag.Parse()
 
-// GetOutputWriter gets the output writer for the specified chromosome
-func (cp *ChromosomeProcessor) GetOutputWriter(chr string) *bufio.Writer {
-	if writer, exists := cp.outputWriters[chr]; exists {
-		return writer
+	if *help {
+		pflag.Usage()
+		os.Exit(0)
 	}
-	return cp.outputWriters[UnknownChr]
-}
 
-// ExtractChromosome extracts the chromosome information from one row
-func (cp *ChromosomeProcessor) ExtractChromosome(line []byte) (string, bool) {
-	result := gjson.GetBytes(line, cp.chrFieldName)
-	if !result.Exists() {
-		return "", false
+	if *quiet && *verbose {
+		log.Fatalf("Error: --quiet and --verbose cannot be used together")
+	}
+	if *countOnly {
+		// a dry run printing nothing but the counts, on every CPU unless
+		// told otherwise
+		*dryRun, *quiet = true, true
+		if !pflag.CommandLine.Changed("workers") && !*mergeSorted {
+			*workers = runtime.NumCPU()
+		}
+	}
+	if *quiet {
+		infoLog.SetOutput(io.Discard)
+	}
+	var verboseLog *log.Logger
+	if *verbose {
+		verboseLog = infoLog
 	}
-	return result.String(), true
-}
-
-// ProcessFile processes the input file
-func (cp *ChromosomeProcessor) ProcessFile() error {
-	fmt.Printf("Processing: %s -> %s_*.jsonl\n", cp.inputFile, cp.prefix)
 
-	if err := cp.InitializeOutputFiles(); err != nil {
-		return err
+	// validate options
+	inputs := append(*inputFiles, pflag.Args()...)
+	if *inputList != "" {
+		listed, err := chrsplit.ReadInputList(*inputList)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		inputs = append(inputs, listed...)
+	}
+	if len(inputs) == 0 {
+		// only fall back to stdin when something is piped in, otherwise the
+		// tool would sit waiting on the terminal
+		if stdinIsTerminal() {
+			fmt.Fprintf(os.Stderr, "Error: Input file is required\n\n")
+			pflag.Usage()
+			os.Exit(1)
+		}
+		inputs = []string{chrsplit.StdinInput}
 	}
-	defer cp.CloseAllFiles()
 
-	file, err := os.Open(cp.inputFile)
+	inputs, err := chrsplit.ExpandInputs(inputs)
 	if err != nil {
-		return fmt.Errorf("failed to open input file: %v", err)
+		log.Fatalf("Error: %v", err)
 	}
-	defer file.Close()
-
-	// !!! row of data may be too large, set buffer size to 10MB
-	scanner := bufio.NewScanner(file)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 10*1024*1024)
-
-	lineNum := 0
-
-	for scanner.Scan() {
-		lineNum++
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
+	if *printSplits > 0 || *byteRange != "" {
+		if len(inputs) != 1 || inputs[0] == chrsplit.StdinInput || chrsplit.IsRemoteInput(inputs[0]) {
+			log.Fatalf("Error: --byte-range and --print-splits need exactly one local input file")
 		}
-
-		chr, found := cp.ExtractChromosome(line)
-
-		outputChr := UnknownChr
-		if found && cp.chrSet[chr] {
-			outputChr = chr
+		if compression := chrsplit.SniffCompression(inputs[0], *inputComp); compression != chrsplit.CompressionNone {
+			log.Fatalf("Error: --byte-range needs uncompressed input, %s is %s", inputs[0], compression)
 		}
+	}
+	if *printSplits > 0 {
+		info, err := os.Stat(inputs[0])
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		for i, r := range chrsplit.SplitRanges(info.Size(), *printSplits) {
+			fmt.Printf("--byte-range %d:%d --shard %d\n", r[0], r[1], i+1)
+		}
+		return
+	}
 
-		writer := cp.GetOutputWriter(outputChr)
-		if _, err := writer.Write(line); err != nil {
-			return fmt.Errorf("failed to write to output file at line %d: %v", lineNum, err)
+	skippedFiles := 0
+	if *recursive {
+		if inputs, skippedFiles, err = chrsplit.WalkInputDirs(inputs, *patterns); err != nil {
+			log.Fatalf("Error: %v", err)
 		}
-		if err := writer.WriteByte('\n'); err != nil {
-			return fmt.Errorf("failed to write newline at line %d: %v", lineNum, err)
+		if len(inputs) == 0 {
+			log.Fatalf("Error: no input file matches %v", *patterns)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading input file at line %d: %v", lineNum, err)
+	if *gzipOutput {
+		if *compress != chrsplit.CompressionNone && *compress != chrsplit.CompressionGzip {
+			log.Fatalf("Error: --gzip cannot be combined with --compress %s", *compress)
+		}
+		*compress = chrsplit.CompressionGzip
+	}
+	if *bgzipOutput {
+		if *compress != chrsplit.CompressionNone && *compress != chrsplit.CompressionBGZF {
+			log.Fatalf("Error: --bgzip cannot be combined with --compress %s", *compress)
+		}
+		*compress = chrsplit.CompressionBGZF
+	}
+	switch *layout {
+	case "flat":
+	case chrsplit.LayoutSubdirs:
+		if *nameTemplate == "" && *shard > 0 {
+			*nameTemplate = "data.shard{shard}{ext}"
+		}
+		*nameTemplate = chrsplit.SubdirTemplate(*nameTemplate)
+	default:
+		log.Fatalf("Error: invalid --layout %q (expected flat or subdirs)", *layout)
+	}
+	if *nameTemplate != "" {
+		if strings.Contains(*nameTemplate, "{input}") && len(inputs) != 1 {
+			log.Fatalf("Error: the {input} placeholder of --filename-template needs a single input, got %d", len(inputs))
+		}
+		// the extension of the template selects the compression, unless
+		// it was given explicitly or the template uses the usual {ext}
+		inferred := chrsplit.CompressionForName(*nameTemplate)
+		explicit := pflag.CommandLine.Changed("compress") || *gzipOutput || *bgzipOutput
+		switch {
+		case strings.HasSuffix(*nameTemplate, "{ext}"):
+		case !explicit:
+			*compress = inferred
+		case inferred != *compress:
+			fmt.Fprintf(os.Stderr, "Warning: writing %s output, although the --filename-template %q extension suggests %s\n", *compress, *nameTemplate, inferred)
+		}
+	}
+	var maxFileBytes int64
+	if *maxFileSize != "" {
+		var err error
+		if maxFileBytes, err = chrsplit.ParseSize(*maxFileSize); err != nil {
+			log.Fatalf("Error: invalid --max-file-size: %v", err)
+		}
+	}
+	var sortBufferBytes int64
+	if *sortByPos {
+		var err error
+		if sortBufferBytes, err = chrsplit.ParseSize(*sortBuffer); err != nil {
+			log.Fatalf("Error: invalid --sort-buffer: %v", err)
+		}
+	}
+	if *copyHeader != 0 {
+		if *jsonHeader != 0 && *jsonHeader != *copyHeader {
+			log.Fatalf("Error: --copy-header %d conflicts with --json-header-lines %d", *copyHeader, *jsonHeader)
+		}
+		*jsonHeader = *copyHeader
+	}
+	headerField, headerValue, ok := strings.Cut(*headerType, "=")
+	if *headerType != "" && (!ok || headerField == "") {
+		log.Fatalf("Error: --header-type-field must be FIELD=VALUE, e.g. type=header")
+	}
+	if *dedupField != "" {
+		*dedup = true
+	}
+	if *statsOut != "" && *statsField == "" {
+		log.Fatalf("Error: --stats-out needs --stats-field")
+	}
+	var fieldDelim byte
+	switch *delimiter {
+	case "":
+	case `\t`, "tab":
+		fieldDelim = '\t'
+	default:
+		if len(*delimiter) != 1 || *delimiter == "\n" || *delimiter == `"` {
+			log.Fatalf("Error: invalid --delimiter %q (expected one ASCII character, or \\t)", *delimiter)
+		}
+		fieldDelim = (*delimiter)[0]
+	}
+	var recordDelimiter string
+	switch *recordDelim {
+	case "":
+	case "nul", `\0`:
+		recordDelimiter = "\x00"
+	case "rs":
+		recordDelimiter = "\x1e"
+	default:
+		unquoted, err := strconv.Unquote(`"` + *recordDelim + `"`)
+		if len(*recordDelim) == 1 {
+			unquoted, err = *recordDelim, nil
+		}
+		if err != nil || len(unquoted) != 1 {
+			log.Fatalf("Error: invalid --record-delimiter %q (expected one byte: nul, rs, a character or an escape like \\x1e)", *recordDelim)
+		}
+		recordDelimiter = unquoted
+	}
+	if *unknownName == "" {
+		log.Fatalf("Error: invalid --unknown-name %q", *unknownName)
+	}
+	var rangeStart, rangeEnd int64
+	if *byteRange != "" {
+		var err error
+		if rangeStart, rangeEnd, err = chrsplit.ParseByteRange(*byteRange); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	if (*sampleRate > 0 || *perChrSample > 0) && *seed == 0 {
+		*seed = rand.Int64()
+	}
+	if *index {
+		if *indexEvery < 1 {
+			log.Fatalf("Error: --index-every must be at least 1")
+		}
+	} else {
+		*indexEvery = 0
+	}
+	if *compThreads < 1 {
+		log.Fatalf("Error: --compress-threads must be at least 1")
+	}
+	if *maxOpenFiles < 1 {
+		log.Fatalf("Error: --max-open-files must be at least 1")
+	}
+	if err := chrsplit.ValidateKeepComments(*keepComments); err != nil {
+		log.Fatalf("Error: %v", err)
 	}
-	cp.FlushAllWriters()
 
-	return nil
-}
+	opts := chrsplit.Options{
+		InputCompression:   *inputComp,
+		InputEncoding:      *inputEncoding,
+		Format:             *format,
+		OutputFormat:       *outputFormat,
+		Fields:             *fields,
+		KeepFields:         *keepFields,
+		Logger:             verboseLog,
+		Workers:            *workers,
+		Mmap:               *mmapInput,
+		DecompressThreads:  *decompThreads,
+		Compress:           *compress,
+		CompressLevel:      *compressLevel,
+		CompressThreads:    *compThreads,
+		HTTPTimeout:        *httpTimeout,
+		HTTPRetries:        *httpRetries,
+		MemberPatterns:     *memberPattern,
+		GCSReadChunkSize:   *gcsChunkSize,
+		ChrFieldRaw:        *chrFieldRaw,
+		Normalize:          *normalize,
+		IgnoreCase:         *ignoreCase,
+		MitoAliases:        *mitoAliases,
+		OutputDir:          *outputDir,
+		Dynamic:            *dynamic,
+		Strict:             *strict,
+		WriteMalformed:     *writeMalform,
+		Validate:           *validate,
+		MaxInvalidFraction: *maxInvalid,
+		MaxRecordBytes:     *maxRecord,
+		SampleUnknown:      *sampleUnknown,
+		SampleRate:         *sampleRate,
+		Seed:               *seed,
+		PerChrSample:       *perChrSample,
+		NoUnknown:          *noUnknown,
+		DropUnknown:        *dropUnknown,
+		UnknownName:        *unknownName,
+		SkipLines:          *skipLines,
+		MaxLines:           *maxLines,
+		ChrColumn:          *chrColumn,
+		ChrColumnIndex:     *chrColumnIdx,
+		Delimiter:          fieldDelim,
+		SampleLogger:       log.New(os.Stderr, "sample: ", 0),
+		OversizePolicy:     *oversize,
+		CommentPrefixes:    *commentPrefix,
+		HeaderPrefix:       *headerPrefix,
+		JSONHeaderLines:    *jsonHeader,
+		HeaderTypeField:    headerField,
+		HeaderTypeValue:    headerValue,
+		MaxFileSize:        maxFileBytes,
+		MaxLinesPerFile:    *maxFileLines,
+		FilterField:        *filterField,
+		FilterOp:           *filterOp,
+		FilterValue:        *filterValue,
+		BinSize:            *binSize,
+		PosFieldName:       *posFieldName,
+		IndexEvery:         *indexEvery,
+		MergeSorted:        *mergeSorted,
+		SortBuffer:         sortBufferBytes,
+		Dedup:              *dedup,
+		DedupField:         *dedupField,
+		StatsField:         *statsField,
+		KeepCommentHeader:  *keepComments == chrsplit.KeepCommentsHeader,
+		Follow:             *follow,
+		FollowSentinel:     *sentinel,
+		IdleTimeout:        *idleTimeout,
+		MaxOpenFiles:       *maxOpenFiles,
+		Force:              *force,
+		Append:             *appendOutput,
+		DryRun:             *dryRun,
+		FilenameTemplate:   *nameTemplate,
+		RecordDelimiter:    recordDelimiter,
+		CountOnly:          *countOnly,
+		Checkpoint:         *checkpointF,
+		CheckpointLines:    *ckptLines,
+		CheckpointInterval: *ckptInterval,
+		Resume:             *resume,
+		RangeStart:         rangeStart,
+		RangeEnd:           rangeEnd,
+		Shard:              *shard,
+	}
+	if err := opts.Check(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *follow {
+		if len(inputs) != 1 || inputs[0] == chrsplit.StdinInput || chrsplit.IsRemoteInput(inputs[0]) {
+			log.Fatalf("Error: --follow needs exactly one local input file")
+		}
+		if compression := chrsplit.SniffCompression(inputs[0], *inputComp); compression != chrsplit.CompressionNone {
+			log.Fatalf("Error: --follow needs uncompressed input, %s is %s", inputs[0], compression)
+		}
+	}
 
-// FlushAllWriters flushes all output writers
-func (cp *ChromosomeProcessor) FlushAllWriters() {
-	for _, writer := range cp.outputWriters {
-		writer.Flush()
+	// parse chromosome names
+	if *dynamic && *chrNamesStr != "" {
+		log.Fatalf("Error: --dynamic and --chr-names cannot be used together")
+	}
+	chrNames := chrsplit.ParseChromosomeNames(*chrNamesStr)
+	if *dynamic {
+		chrNames = nil
 	}
-}
 
-// CloseAllFiles closes all output files
-func (cp *ChromosomeProcessor) CloseAllFiles() {
-	cp.FlushAllWriters()
-	for _, file := range cp.outputFiles {
-		file.Close()
+	if !*quiet {
+		infoLog.Printf("Configuration:\n")
+		infoLog.Printf("  Input files: %d\n", len(inputs))
+		for _, input := range inputs {
+			infoLog.Printf("    %s (compression: %s)\n", chrsplit.InputDisplayName(input), chrsplit.SniffCompression(input, *inputComp))
+		}
+		if abs, err := filepath.Abs(*outputDir); err == nil {
+			infoLog.Printf("  Output directory: %s\n", abs)
+		} else {
+			infoLog.Printf("  Output directory: %s\n", *outputDir)
+		}
+		infoLog.Printf("  Output prefix: %s\n", *prefix)
+		infoLog.Printf("  Workers: %d\n", *workers)
+		infoLog.Printf("  Max open files: %d\n", *maxOpenFiles)
+		infoLog.Printf("  Decompress threads: %d\n", *decompThreads)
+		if *compressLevel != 0 {
+			infoLog.Printf("  Output compression: %s (level %d)\n", *compress, *compressLevel)
+		} else {
+			infoLog.Printf("  Output compression: %s\n", *compress)
+		}
+		if *compThreads > 1 {
+			infoLog.Printf("  Compress threads: %d\n", *compThreads)
+		}
+		if *chrFieldRaw {
+			infoLog.Printf("  Chromosome field: %s (literal key)\n", *chrFieldName)
+		} else {
+			infoLog.Printf("  Chromosome field: %s\n", *chrFieldName)
+		}
+		if *dynamic {
+			infoLog.Printf("  Target chromosomes: dynamic (one output per distinct value)\n")
+		} else {
+			infoLog.Printf("  Target chromosomes: %v\n", chrsplit.SortChromosomes(chrNames))
+		}
+		if *sortByPos {
+			infoLog.Printf("  Sort by position: %s (buffer %s per output)\n", *posFieldName, *sortBuffer)
+		}
+		if *sampleRate > 0 {
+			infoLog.Printf("  Sample rate: %g (seed %d)\n", *sampleRate, *seed)
+		}
+		if *perChrSample > 0 {
+			infoLog.Printf("  Per-chromosome sample: %d records (seed %d)\n", *perChrSample, *seed)
+		}
+		if *dedupField != "" {
+			infoLog.Printf("  Deduplicate: on field %s\n", *dedupField)
+		} else if *dedup {
+			infoLog.Printf("  Deduplicate: whole records\n")
+		}
+		if *index {
+			infoLog.Printf("  Index: every %d records (field %s)\n", *indexEvery, *posFieldName)
+		}
+		if *binSize > 0 {
+			infoLog.Printf("  Position bins: %d (field %s)\n", *binSize, *posFieldName)
+		}
+		if *normalize {
+			infoLog.Printf("  Normalize names: yes (mitochondrial aliases: %v)\n", *mitoAliases)
+		} else if *ignoreCase {
+			infoLog.Printf("  Ignore case: yes\n")
+		}
+		if *byteRange != "" {
+			infoLog.Printf("  Byte range: %d-%d (shard %d)\n", rangeStart, rangeEnd, *shard)
+		}
+		infoLog.Println()
 	}
-}
 
-// getDefaultChromosomes returns the default list of chromosome names
-func getDefaultChromosomes() []string {
-	chroms := make([]string, 0, 25)
+	// the manifest of the runs appended to gives the totals of the summary;
+	// a broken one fails before anything is appended
+	var prior *chrsplit.Manifest
+	if *appendOutput && *manifest != "" && *manifest != "-" {
+		if _, err := os.Stat(*manifest); err == nil {
+			m, err := chrsplit.ReadManifest(*manifest)
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			prior = &m
+		}
+	}
 
-	// chr1-chr22
-	for i := 1; i <= 22; i++ {
-		chroms = append(chroms, fmt.Sprintf("chr%d", i))
+	processor := chrsplit.NewChromosomeProcessor(inputs, *prefix, *chrFieldName, chrNames, opts)
+	switch {
+	case *dryRun:
+		infoLog.Printf("Processing: %d input(s) (dry run, no output files)\n", len(inputs))
+	case *resume:
+		infoLog.Printf("Resuming: %d input(s) from %s -> %s\n", len(inputs), *checkpointF, processor.OutputPath("*"))
+	default:
+		infoLog.Printf("Processing: %d input(s) -> %s\n", len(inputs), processor.OutputPath("*"))
+	}
+	// on Ctrl-C or SIGTERM stop before the next line and close the outputs
+	// cleanly (with --follow the run then finishes as usual); a second
+	// signal kills the process
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		processor.Stop()
+	}()
+
+	if *progressEvery > 0 && !*quiet {
+		done := make(chan struct{})
+		defer close(done)
+		go reportProgress(processor, *progressEvery, *maxLines, done)
 	}
 
-	// chrX, chrY, chrM
-	chroms = append(chroms, "chrX", "chrY", "chrM")
+	if err := processor.ProcessFile(); errors.Is(err, chrsplit.ErrInterrupted) {
+		printSummary(processor, *nameTemplate != "" && !*dryRun, *appendOutput, nil)
+		lines := 0
+		for _, n := range processor.Stats() {
+			lines += n
+		}
+		fmt.Fprintf(os.Stderr, "Interrupted after %d lines; the partial outputs are kept as %s\n", lines, processor.TempPath("*"))
+		if *checkpointF != "" {
+			fmt.Fprintf(os.Stderr, "Run again with --checkpoint %s --resume to continue\n", *checkpointF)
+		}
+		os.Exit(exitInterrupted)
+	} else if err != nil {
+		log.Fatalf("Error processing file: %v", err)
+	} else {
+		if *manifest != "" {
+			if err := processor.WriteManifest(*manifest, time.Since(startTime)); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		}
+		if *countOnly {
+			stats := processor.Stats()
+			for _, chr := range processor.SummaryChromosomes() {
+				fmt.Printf("%s\t%d\n", chr, stats[chr])
+			}
+		}
+		if *statsOut != "" {
+			if err := processor.WriteFieldStatsFile(*statsOut); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		}
+		printSummary(processor, *nameTemplate != "" && !*dryRun, *appendOutput, prior)
+		if *statsField != "" && *statsOut == "" {
+			infoLog.Printf("Values of %s:\n", *statsField)
+			if err := processor.WriteFieldStats(infoLog.Writer()); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		}
+		if *recursive {
+			infoLog.Printf("Files: %d processed, %d skipped by --pattern\n", len(inputs), skippedFiles)
+		}
+		infoLog.Printf("Finished in %.2f s\n", time.Since(startTime).Seconds())
+	}
 
-	return chroms
 }
 
-// parseChromosomeNames parses the comma-separated chromosome names string
-func parseChromosomeNames(chrNamesStr string) []string {
-	if chrNamesStr == "" {
-		return getDefaultChromosomes()
-	}
-
-	parts := strings.Split(chrNamesStr, ",")
-	chrNames := make([]string, 0, len(parts))
+// reportProgress prints a progress line to stderr each time the lines routed
+// reach the next multiple of every, until done is closed. When the total
+// input size is known, the line includes the percentage of input bytes read
+// and an ETA extrapolated from the rate so far; otherwise (stdin, remote or
+// zip inputs) it shows line counts only.
+// With maxLines the run ends at that many lines or at the end of the input,
+// whichever comes first.
+func reportProgress(processor *chrsplit.ChromosomeProcessor, every, maxLines int, done <-chan struct{}) {
+	start := time.Now()
+	size := processor.InputSize()
+	ticker := time.NewTicker(progressPoll)
+	defer ticker.Stop()
+
+	lastReported := int64(0)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
 
-	for _, part := range parts {
-		name := strings.TrimSpace(part)
-		if name != "" {
-			chrNames = append(chrNames, name)
+		lines, bytes := processor.Progress()
+		if lines/int64(every) == lastReported/int64(every) {
+			continue
+		}
+		lastReported = lines
+		elapsed := time.Since(start)
+		rate := float64(lines) / elapsed.Seconds()
+		var fraction float64
+		if size > 0 && bytes > 0 {
+			fraction = min(float64(bytes)/float64(size), 1)
+		}
+		if maxLines > 0 {
+			fraction = max(fraction, min(float64(lines)/float64(maxLines), 1))
+		}
+		if fraction == 0 {
+			infoLog.Printf("Progress: %d lines (%.0f lines/s)\n", lines, rate)
+			continue
 		}
+		eta := time.Duration(float64(elapsed) * (1 - fraction) / fraction).Round(time.Second)
+		infoLog.Printf("Progress: %d lines (%.0f lines/s), %.1f%%, ETA %s\n", lines, rate, fraction*100, eta)
 	}
-
-	return chrNames
 }
 
-func main() {
-
-	startTime := time.Now()
+// printSummary prints the per-chromosome and per-input line counts and the
+// bytes written; with showFiles, every count names its output file. An
+// appending run marks its counts as appended, with the totals including
+// the earlier runs of the prior manifest, if any.
+func printSummary(processor *chrsplit.ChromosomeProcessor, showFiles, appended bool, prior *chrsplit.Manifest) {
+	stats := processor.Stats()
+
+	infoLog.Printf("Summary:\n")
+	for _, chr := range processor.SummaryChromosomes() {
+		line := fmt.Sprintf("  %s: %d", chr, stats[chr])
+		if appended {
+			line += " appended"
+			if prior != nil {
+				line += fmt.Sprintf(", %d total", prior.ChromosomeLines(chr)+stats[chr])
+			}
+		}
+		if scanned := processor.Scanned(); len(scanned) > 0 {
+			line += fmt.Sprintf(" sampled of %d", scanned[chr])
+		}
+		if ratio, ok := processor.CompressionRatio(chr); ok {
+			line += fmt.Sprintf(" (%.1fx compression)", ratio)
+		}
+		parts := processor.OutputParts(chr)
+		if showFiles && len(parts) == 1 {
+			line += " -> " + parts[0].Path
+		}
+		infoLog.Printf("%s\n", line)
+		if len(parts) > 1 {
+			for _, part := range parts {
+				infoLog.Printf("    %s: %d\n", part.Path, part.Lines)
+			}
+		}
+	}
 
-	// parse command line options
-	var (
-		inputFile    = pflag.StringP("input", "i", "", "Input JSONL file path (required)")
-		prefix       = pflag.String("prefix", "output", "Output file prefix")
-		chrFieldName = pflag.String("chr-field-name", "chr", "Chromosome field name in JSON")
-		chrNamesStr  = pflag.StringP("chr-names", "c", "", "Custom chromosome names (comma-separated)")
-		help         = pflag.BoolP("help", "h", false, "Show help message")
-	)
+	infoLog.Printf("Inputs:\n")
+	inputs := processor.InputStats()
+	unit := processor.RecordUnit()
+	var totalLines int
+	var totalBytes int64
+	for _, input := range inputs {
+		infoLog.Printf("  %s: %d %s, %d bytes read\n", input.Input, input.Lines, unit, input.Bytes)
+		totalLines += input.Lines
+		totalBytes += input.Bytes
+	}
+	if len(inputs) > 1 {
+		infoLog.Printf("  total: %d %s, %d bytes read from %d inputs\n", totalLines, unit, totalBytes, len(inputs))
+	}
 
-	pflag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "A tool to split a JSONL/NDJSON file by chromosome\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Options:\n")
-		pflag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\nExamples:\n")
-		fmt.Fprintf(os.Stderr, "  %s --input input.jsonl --prefix output\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -i input.jsonl --prefix output\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -i data.jsonl  --prefix result --chr-field-name chromosome\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -i data.jsonl  --chr-names \"chr1,chr2,chrX\"\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -i data.jsonl  -c \"chr1,chr2,chrX\" --prefix my_output\n", os.Args[0])
+	if routed, written := processor.OutputBytes(); routed > 0 && written > 0 {
+		if written != routed {
+			infoLog.Printf("Output bytes: %d routed, %d written (%.1fx compression)\n", routed, written, float64(routed)/float64(written))
+		} else {
+			infoLog.Printf("Output bytes: %d written\n", written)
+		}
 	}
 
-	pflag.Parse()
+	if n := processor.Filtered(); n > 0 {
+		infoLog.Printf("Filtered records: %d\n", n)
+	}
 
-	if *help {
-		pflag.Usage()
-		os.Exit(0)
+	if n := processor.Comments(); n > 0 {
+		infoLog.Printf("Comment lines skipped: %d\n", n)
 	}
 
-	// validate options
-	if *inputFile == "" {
-		fmt.Fprintf(os.Stderr, "Error: Input file is required\n\n")
-		pflag.Usage()
-		os.Exit(1)
+	if n := processor.DroppedUnknown(); n > 0 {
+		infoLog.Printf("Unknown records dropped: %d\n", n)
 	}
 
-	if _, err := os.Stat(*inputFile); os.IsNotExist(err) {
-		log.Fatalf("Error: Input file does not exist: %s", *inputFile)
+	if n := processor.Deduplicated(); n > 0 {
+		infoLog.Printf("Deduplicated: %d\n", n)
 	}
 
-	// parse chromosome names
-	chrNames := parseChromosomeNames(*chrNamesStr)
+	if n := processor.FASTALines(); n > 0 {
+		infoLog.Printf("FASTA lines: %d -> %s\n", n, processor.OutputPath(chrsplit.FastaChr))
+	}
 
-	fmt.Printf("Configuration:\n")
-	fmt.Printf("  Input file: %s\n", *inputFile)
-	fmt.Printf("  Output prefix: %s\n", *prefix)
-	fmt.Printf("  Chromosome field: %s\n", *chrFieldName)
-	fmt.Printf("  Target chromosomes: %v\n", chrNames)
-	fmt.Println()
+	if n := processor.Oversize(); n > 0 {
+		infoLog.Printf("Oversize records: %d\n", n)
+	}
 
-	processor := NewChromosomeProcessor(*inputFile, *prefix, *chrFieldName, chrNames)
-	if err := processor.ProcessFile(); err != nil {
-		log.Fatalf("Error processing file: %v", err)
-	} else {
-		fmt.Printf("Finished in %.2f s\n", time.Since(startTime).Seconds())
+	if n, lines := processor.Malformed(); n > 0 {
+		infoLog.Printf("Malformed lines: %d\n", n)
+		for _, line := range lines {
+			infoLog.Printf("  %s line %d\n", line.Input, line.Line)
+		}
+		if n > len(lines) {
+			infoLog.Printf("  ... and %d more\n", n-len(lines))
+		}
 	}
+}
 
+// stdinIsTerminal reports whether standard input is attached to a terminal
+// rather than a pipe or a redirected file
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,101 @@
+package chrsplit
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	asyncChunkSize  = 1024 * 1024
+	asyncChunkDepth = 8
+)
+
+// asyncChunk is one block of decoded data handed from the decoder goroutine
+type asyncChunk struct {
+	buf []byte
+	n   int
+	err error
+}
+
+// asyncReader runs a slow decoder (bzip2, xz) on its own goroutine and hands
+// the decoded data over a buffered channel, so decompression overlaps with
+// routing and writing in the scan loop
+type asyncReader struct {
+	chunks  chan asyncChunk
+	free    chan []byte
+	done    chan struct{}
+	once    sync.Once
+	pending []byte
+	cur     []byte
+	err     error
+}
+
+// newAsyncReader starts decoding r in the background
+func newAsyncReader(r io.Reader) *asyncReader {
+	ar := &asyncReader{
+		chunks: make(chan asyncChunk, asyncChunkDepth),
+		free:   make(chan []byte, asyncChunkDepth+2),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < asyncChunkDepth+2; i++ {
+		ar.free <- make([]byte, asyncChunkSize)
+	}
+	go ar.run(r)
+	return ar
+}
+
+func (ar *asyncReader) run(r io.Reader) {
+	defer close(ar.chunks)
+	for {
+		var buf []byte
+		select {
+		case buf = <-ar.free:
+		case <-ar.done:
+			return
+		}
+
+		n, err := io.ReadFull(r, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+
+		select {
+		case ar.chunks <- asyncChunk{buf: buf, n: n, err: err}:
+		case <-ar.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (ar *asyncReader) Read(p []byte) (int, error) {
+	for len(ar.cur) == 0 {
+		if ar.pending != nil {
+			ar.free <- ar.pending
+			ar.pending = nil
+		}
+		if ar.err != nil {
+			return 0, ar.err
+		}
+
+		chunk, ok := <-ar.chunks
+		if !ok {
+			return 0, io.EOF
+		}
+		ar.pending = chunk.buf
+		ar.cur = chunk.buf[:chunk.n]
+		ar.err = chunk.err
+	}
+
+	n := copy(p, ar.cur)
+	ar.cur = ar.cur[n:]
+	return n, nil
+}
+
+// Close stops the decoder goroutine
+func (ar *asyncReader) Close() error {
+	ar.once.Do(func() { close(ar.done) })
+	return nil
+}
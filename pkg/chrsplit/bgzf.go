@@ -0,0 +1,239 @@
+package chrsplit
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// bgzfHeaderLen is the length of a standard BGZF block header (gzip header
+// plus the 6-byte BC extra subfield)
+const bgzfHeaderLen = 18
+
+// bgzfBlockSize parses the total size of a BGZF block from its header. It
+// reports false when the header is not a gzip member carrying the BC subfield.
+func bgzfBlockSize(header []byte) (int, bool) {
+	if len(header) < 12 || header[0] != 0x1f || header[1] != 0x8b || header[2] != 8 || header[3]&0x04 == 0 {
+		return 0, false
+	}
+	xlen := int(binary.LittleEndian.Uint16(header[10:12]))
+	if len(header) < 12+xlen {
+		return 0, false
+	}
+
+	extra := header[12 : 12+xlen]
+	for len(extra) >= 4 {
+		slen := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+slen {
+			break
+		}
+		if extra[0] == 'B' && extra[1] == 'C' && slen == 2 {
+			return int(binary.LittleEndian.Uint16(extra[4:6])) + 1, true
+		}
+		extra = extra[4+slen:]
+	}
+	return 0, false
+}
+
+// readBGZFBlock reads one raw (still compressed) BGZF block
+func readBGZFBlock(r io.Reader) ([]byte, error) {
+	header := make([]byte, 12, bgzfHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated block header")
+		}
+		return nil, err
+	}
+
+	xlen := int(binary.LittleEndian.Uint16(header[10:12]))
+	header = append(header, make([]byte, xlen)...)
+	if _, err := io.ReadFull(r, header[12:]); err != nil {
+		return nil, fmt.Errorf("truncated block header")
+	}
+
+	size, ok := bgzfBlockSize(header)
+	if !ok || size < len(header)+8 {
+		return nil, fmt.Errorf("invalid block header (not a BGZF block)")
+	}
+
+	block := make([]byte, size)
+	copy(block, header)
+	if _, err := io.ReadFull(r, block[len(header):]); err != nil {
+		return nil, fmt.Errorf("truncated block")
+	}
+	return block, nil
+}
+
+// inflateBGZFBlock decompresses a raw BGZF block and verifies its trailer
+func inflateBGZFBlock(block []byte) ([]byte, error) {
+	xlen := int(binary.LittleEndian.Uint16(block[10:12]))
+	cdata := block[12+xlen : len(block)-8]
+	crc := binary.LittleEndian.Uint32(block[len(block)-8:])
+	isize := binary.LittleEndian.Uint32(block[len(block)-4:])
+
+	data := make([]byte, isize)
+	fr := flate.NewReader(bytes.NewReader(cdata))
+	defer fr.Close()
+	if _, err := io.ReadFull(fr, data); err != nil {
+		return nil, fmt.Errorf("failed to inflate block: %v", err)
+	}
+	if crc32.ChecksumIEEE(data) != crc {
+		return nil, fmt.Errorf("block checksum mismatch")
+	}
+	return data, nil
+}
+
+// bgzfResult is the decompressed content of one block
+type bgzfResult struct {
+	data []byte
+	err  error
+}
+
+// bgzfJob is a raw block waiting for a worker, plus where to put the result
+type bgzfJob struct {
+	block  []byte
+	result chan bgzfResult
+}
+
+// bgzfReader decompresses BGZF blocks on a pool of worker goroutines and
+// returns the decompressed data in the original block order
+type bgzfReader struct {
+	ordered chan chan bgzfResult
+	done    chan struct{}
+	once    sync.Once
+	cur     []byte
+	err     error
+}
+
+// newBGZFReader starts reading blocks from r and inflating them with the
+// given number of worker goroutines
+func newBGZFReader(r io.Reader, threads int) *bgzfReader {
+	if threads < 1 {
+		threads = 1
+	}
+
+	br := &bgzfReader{
+		ordered: make(chan chan bgzfResult, threads*4),
+		done:    make(chan struct{}),
+	}
+	jobs := make(chan bgzfJob, threads)
+
+	for i := 0; i < threads; i++ {
+		go func() {
+			for job := range jobs {
+				data, err := inflateBGZFBlock(job.block)
+				job.result <- bgzfResult{data: data, err: err}
+			}
+		}()
+	}
+
+	// blocks are queued on ordered before being handed to the pool, so the
+	// consumer waits on results in file order however the workers finish
+	go func() {
+		defer close(jobs)
+		defer close(br.ordered)
+		for {
+			block, err := readBGZFBlock(r)
+			if err == io.EOF {
+				return
+			}
+
+			result := make(chan bgzfResult, 1)
+			select {
+			case br.ordered <- result:
+			case <-br.done:
+				return
+			}
+
+			if err != nil {
+				result <- bgzfResult{err: err}
+				return
+			}
+
+			select {
+			case jobs <- bgzfJob{block: block, result: result}:
+			case <-br.done:
+				return
+			}
+		}
+	}()
+
+	return br
+}
+
+func (br *bgzfReader) Read(p []byte) (int, error) {
+	for len(br.cur) == 0 {
+		if br.err != nil {
+			return 0, br.err
+		}
+
+		result, ok := <-br.ordered
+		if !ok {
+			br.err = io.EOF
+			continue
+		}
+		res := <-result
+		br.cur, br.err = res.data, res.err
+	}
+
+	n := copy(p, br.cur)
+	br.cur = br.cur[n:]
+	return n, nil
+}
+
+// Close stops the block reader and the worker pool
+func (br *bgzfReader) Close() error {
+	br.once.Do(func() { close(br.done) })
+	return nil
+}
+
+// bgzfMaxInput is the most data put into one BGZF block, as in htslib, so
+// even incompressible data fits the 64 KiB block limit
+const bgzfMaxInput = 0xff00
+
+// bgzfEOF is the empty block that marks the end of a BGZF file
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43,
+	0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+// newBGZFWriter returns a writer of BGZF (bgzip) blocks: gzip members of
+// at most 64 KiB that carry their size in the BC subfield, so tabix and
+// samtools can seek into the file. The file ends with the EOF marker block.
+func newBGZFWriter(w io.Writer, level, threads int) (*blockWriter, error) {
+	return newBlockWriter(w, bgzfMaxInput, threads, bgzfEOF, func() (blockEncoder, error) {
+		fw, err := flate.NewWriter(nil, level)
+		if err != nil {
+			return nil, err
+		}
+		return &bgzfEncoder{fw: fw}, nil
+	})
+}
+
+// bgzfEncoder compresses one BGZF block
+type bgzfEncoder struct {
+	fw *flate.Writer
+}
+
+func (e *bgzfEncoder) encode(dst *bytes.Buffer, src []byte) error {
+	start := dst.Len()
+	dst.Write([]byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 0x06, 0x00, 'B', 'C', 0x02, 0x00, 0, 0})
+	e.fw.Reset(dst)
+	if _, err := e.fw.Write(src); err != nil {
+		return err
+	}
+	if err := e.fw.Close(); err != nil {
+		return err
+	}
+	trailer := binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(src))
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(len(src)))
+	dst.Write(trailer)
+
+	block := dst.Bytes()[start:]
+	binary.LittleEndian.PutUint16(block[16:18], uint16(len(block)-1))
+	return nil
+}
@@ -0,0 +1,63 @@
+package chrsplit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// NoPosBin is the suffix of the output of a chromosome's records without a
+// valid position when binning (e.g. prefix_chr1_nopos.jsonl)
+const NoPosBin = "nopos"
+
+// ExtractPosition extracts the position from one row (POS of a VCF or SAM
+// record, start of a BED or GFF record); it reports false when the field is
+// missing or not a non-negative integer
+func (cp *ChromosomeProcessor) ExtractPosition(line []byte) (int64, bool) {
+	if cp.isText() {
+		column := cp.posColumn()
+		if column < 0 {
+			return 0, false
+		}
+		col, _ := cp.textColumn(line, column)
+		pos, err := strconv.ParseInt(string(col), 10, 64)
+		return pos, err == nil && pos >= 0
+	}
+	if cp.isMsgpack() {
+		value, ok := msgpackLookup(line, cp.posPath)
+		if !ok {
+			return 0, false
+		}
+		pos, ok := msgpackNumber(value)
+		return int64(pos), ok && pos >= 0 && pos == math.Trunc(pos)
+	}
+	result := gjson.GetBytes(line, cp.opts.PosFieldName)
+	if result.Type != gjson.Number || result.Num < 0 || result.Num != math.Trunc(result.Num) {
+		return 0, false
+	}
+	return result.Int(), true
+}
+
+// binOutput returns the output of a record of chr with BinSize set: the bin
+// of its position, e.g. "chr1_bin0000123" for pos / BinSize = 123, or
+// "chr1_nopos". The special outputs and UnknownChr are not binned.
+func (cp *ChromosomeProcessor) binOutput(chr string, line []byte) string {
+	switch chr {
+	case cp.unknownChr, UnmappedChr, MalformedChr, OversizeChr, commentChr, headerChr, filteredChr, regionChr, fastaStartChr:
+		return chr
+	}
+	pos, ok := cp.ExtractPosition(line)
+	if !ok {
+		return chr + "_" + NoPosBin
+	}
+	return fmt.Sprintf("%s_bin%07d", chr, pos/cp.opts.BinSize)
+}
+
+// lazyOutputs reports whether the outputs are only known once records show
+// up (dynamic mode or position bins), so they are created on first use and
+// listed from the counts
+func (cp *ChromosomeProcessor) lazyOutputs() bool {
+	return cp.opts.Dynamic || cp.opts.BinSize > 0
+}
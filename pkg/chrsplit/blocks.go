@@ -0,0 +1,138 @@
+package chrsplit
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// compressBlockSize is the input of each gzip member or zstd frame written
+// by a multi-threaded output; large enough that the ratio barely suffers
+// from the blocks compressing independently
+const compressBlockSize = 1 << 20
+
+// blockEncoder compresses one block on its own: a BGZF block, a gzip
+// member or a zstd frame
+type blockEncoder interface {
+	encode(dst *bytes.Buffer, src []byte) error
+}
+
+// blockWriter compresses its output in independent blocks, which
+// concatenate into one valid file. It fills one block per thread and
+// compresses them side by side before writing them in order, so the file
+// does not depend on the number of threads. Outputs are only written from
+// one goroutine, so however many outputs are open, at most threads blocks
+// are compressed at once. Close writes trailer, if any.
+type blockWriter struct {
+	w       io.Writer
+	size    int
+	blocks  []*compressBlock
+	trailer []byte
+	// filled is the number of full blocks waiting to be compressed
+	filled int
+	wrote  bool
+}
+
+// compressBlock is the input of one block, its encoder and the compressed
+// block
+type compressBlock struct {
+	buf []byte
+	enc blockEncoder
+	out bytes.Buffer
+	err error
+}
+
+func newBlockWriter(w io.Writer, size, threads int, trailer []byte, newEncoder func() (blockEncoder, error)) (*blockWriter, error) {
+	bw := &blockWriter{w: w, size: size, trailer: trailer}
+	for range max(threads, 1) {
+		enc, err := newEncoder()
+		if err != nil {
+			return nil, err
+		}
+		bw.blocks = append(bw.blocks, &compressBlock{buf: make([]byte, 0, size), enc: enc})
+	}
+	return bw, nil
+}
+
+// Write buffers p, writing the blocks each time every thread has a full one
+func (bw *blockWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		b := bw.blocks[bw.filled]
+		n := min(len(p), bw.size-len(b.buf))
+		b.buf = append(b.buf, p[:n]...)
+		p = p[n:]
+		if len(b.buf) == bw.size {
+			bw.filled++
+			if bw.filled == len(bw.blocks) {
+				if err := bw.writeBlocks(bw.filled); err != nil {
+					return written, err
+				}
+			}
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// writeBlocks compresses the first n buffered blocks, concurrently when
+// there are several, and writes them in order
+func (bw *blockWriter) writeBlocks(n int) error {
+	if n == 1 {
+		bw.blocks[0].compress()
+	} else {
+		var wg sync.WaitGroup
+		for _, b := range bw.blocks[:n] {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.compress()
+			}()
+		}
+		wg.Wait()
+	}
+	bw.filled = 0
+	bw.wrote = true
+	for _, b := range bw.blocks[:n] {
+		b.buf = b.buf[:0]
+		if b.err != nil {
+			return b.err
+		}
+		if _, err := bw.w.Write(b.out.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compress encodes the buffered data into out
+func (b *compressBlock) compress() {
+	b.out.Reset()
+	b.err = b.enc.encode(&b.out, b.buf)
+}
+
+// Flush writes the buffered data, ending with a short block
+func (bw *blockWriter) Flush() error {
+	n := bw.filled
+	if len(bw.blocks[n].buf) > 0 {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	return bw.writeBlocks(n)
+}
+
+// Close writes the buffered data and the trailer. Without a trailer, an
+// output that got no data still gets one empty block, so it is a valid
+// compressed file rather than an empty one.
+func (bw *blockWriter) Close() error {
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if bw.trailer == nil && !bw.wrote {
+		return bw.writeBlocks(1)
+	}
+	_, err := bw.w.Write(bw.trailer)
+	return err
+}
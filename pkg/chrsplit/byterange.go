@@ -0,0 +1,115 @@
+package chrsplit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ParseByteRange parses a --byte-range value, START:END with END exclusive;
+// both take sizes such as 100GB, and START may be 0
+func ParseByteRange(value string) (start, end int64, err error) {
+	from, to, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid byte range %q (expected START:END)", value)
+	}
+	if start, err = parseSize(from, true); err != nil {
+		return 0, 0, fmt.Errorf("invalid byte range %q: %v", value, err)
+	}
+	if end, err = ParseSize(to); err != nil {
+		return 0, 0, fmt.Errorf("invalid byte range %q: %v", value, err)
+	}
+	if end <= start {
+		return 0, 0, fmt.Errorf("invalid byte range %q: the end must be after the start", value)
+	}
+	return start, end, nil
+}
+
+// SplitRanges divides size bytes into n ranges of (nearly) the same size,
+// to be passed as --byte-range to n instances
+func SplitRanges(size int64, n int) [][2]int64 {
+	ranges := make([][2]int64, 0, n)
+	for i := range int64(n) {
+		ranges = append(ranges, [2]int64{size * i / int64(n), size * (i + 1) / int64(n)})
+	}
+	return ranges
+}
+
+// rangeReader reads the records of an input owned by the RangeStart and
+// RangeEnd options: those starting inside the range. A record cut by the
+// start belongs to the range before, and the record cut by the end is read
+// to its end, so ranges splitting an input between them get every record
+// exactly once.
+type rangeReader struct {
+	r     io.Reader
+	delim byte
+	// pos is the offset in the input of the next byte read
+	pos, end int64
+	skipping bool
+	done     bool
+}
+
+// newRangeReader positions file for the range [start, end). Unless the
+// range starts the input, reading starts at the byte before it: when that
+// byte ends a record, the range starts with the next one, otherwise the
+// partial record up to the first delimiter is skipped.
+func newRangeReader(file *os.File, start, end int64, delim byte) (*rangeReader, error) {
+	rr := &rangeReader{r: file, delim: delim, end: end}
+	if start > 0 {
+		rr.pos, rr.skipping = start-1, true
+	}
+	if _, err := file.Seek(rr.pos, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to the byte range: %v", err)
+	}
+	return rr, nil
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	for {
+		if rr.done {
+			return 0, io.EOF
+		}
+		n, err := rr.r.Read(p)
+		data := p[:n]
+		if rr.skipping {
+			if i := bytes.IndexByte(data, rr.delim); i >= 0 {
+				rr.pos += int64(i + 1)
+				data = data[i+1:]
+				rr.skipping = false
+				// a record starting at the end belongs to the next range
+				rr.done = rr.pos >= rr.end
+			} else {
+				rr.pos += int64(n)
+				data = nil
+			}
+		}
+		if !rr.done && len(data) > 0 {
+			// the first delimiter from the last byte of the range on ends
+			// the last record of the range
+			from := max(rr.end-1-rr.pos, 0)
+			if from < int64(len(data)) {
+				if i := bytes.IndexByte(data[from:], rr.delim); i >= 0 {
+					data = data[:from+int64(i)+1]
+					rr.done = true
+				}
+			}
+			rr.pos += int64(len(data))
+		} else if rr.done {
+			data = nil
+		}
+		n = copy(p, data)
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// shardSuffix returns the suffix Shard adds to the output names
+func (cp *ChromosomeProcessor) shardSuffix() string {
+	if cp.opts.Shard == 0 {
+		return ""
+	}
+	return fmt.Sprintf(".shard%03d", cp.opts.Shard)
+}
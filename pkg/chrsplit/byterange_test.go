@@ -0,0 +1,97 @@
+package chrsplit
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end int64
+	}{
+		{"0:100", 0, 100},
+		{"0B:1KB", 0, 1000},
+		{"1GB:2GB", 1e9, 2e9},
+	}
+	for _, tt := range tests {
+		start, end, err := ParseByteRange(tt.value)
+		if err != nil || start != tt.start || end != tt.end {
+			t.Errorf("ParseByteRange(%q) = %d, %d, %v, want %d, %d", tt.value, start, end, err, tt.start, tt.end)
+		}
+	}
+
+	for _, value := range []string{"", "100", "0:0", "10:5", "-1:5", "NaN:5", "0:Inf", "0:1e30TB", ":5", "5:"} {
+		if start, end, err := ParseByteRange(value); err == nil {
+			t.Errorf("ParseByteRange(%q) = %d, %d, want an error", value, start, end)
+		}
+	}
+}
+
+// readRange returns the bytes a rangeReader for [start, end) reads from path,
+// one byte per underlying read when oneByte is set
+func readRange(t *testing.T, path string, start, end int64, oneByte bool) string {
+	t.Helper()
+	file := mustOpen(t, path)
+	rr, err := newRangeReader(file, start, end, '\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oneByte {
+		rr.r = iotest.OneByteReader(file)
+	}
+	data, err := io.ReadAll(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRangeReaderBoundaries(t *testing.T) {
+	input := writeInput(t, "in.jsonl", []string{`{"chr":"chr1"}`, `{"chr":"chr2","pos":12}`, `{}`, `{"chr":"chrX","id":"rs3"}`}, "\n")
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(data))
+
+	// every cut, on a delimiter, right after one or inside a record: the
+	// ranges on both sides of it together read every record exactly once
+	for _, oneByte := range []bool{false, true} {
+		for cut := int64(1); cut < size; cut++ {
+			before := readRange(t, input, 0, cut, oneByte)
+			after := readRange(t, input, cut, size, oneByte)
+			if before+after != string(data) {
+				t.Fatalf("cut at %d (byte %q): got %q + %q", cut, data[cut], before, after)
+			}
+			if before != "" && !strings.HasSuffix(before, "\n") {
+				t.Errorf("cut at %d: the range before ends mid-record: %q", cut, before)
+			}
+		}
+	}
+}
+
+func TestRangeReaderRecordStarts(t *testing.T) {
+	input := writeInput(t, "in.jsonl", []string{"a", "bb", "ccc"}, "\n")
+	for _, test := range []struct {
+		start, end int64
+		want       string
+	}{
+		{0, 1, "a\n"},     // ends on the delimiter of a
+		{0, 2, "a\n"},     // a record starting at the end belongs to the next range
+		{0, 3, "a\nbb\n"}, // ends inside bb, which is read to its end
+		{1, 3, "bb\n"},    // starts on the delimiter of a
+		{2, 5, "bb\n"},    // starts on the first byte of bb
+		{3, 5, ""},        // starts inside bb, ends on the first byte of ccc
+		{3, 6, "ccc\n"},   // starts inside bb and owns ccc
+		{5, 100, "ccc\n"}, // ends past the end of the input
+		{0, 100, "a\nbb\nccc\n"},
+	} {
+		if got := readRange(t, input, test.start, test.end, false); got != test.want {
+			t.Errorf("range %d:%d: got %q, want %q", test.start, test.end, got, test.want)
+		}
+	}
+}
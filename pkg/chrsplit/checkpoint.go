@@ -0,0 +1,268 @@
+package chrsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// checkpoint is the progress of a run as recorded in the Checkpoint file:
+// where reading stopped, the size of every output at that point and the
+// state routing needs to carry on from there
+type checkpoint struct {
+	Inputs []string `json:"inputs"`
+	// Input is the index in Inputs of the input being read; Offset, Line
+	// and Routed are the bytes, records and routed records of it read
+	Input  int   `json:"input"`
+	Offset int64 `json:"offset"`
+	Line   int   `json:"line"`
+	Routed int   `json:"routed"`
+	// Sizes is the size of every output file created so far
+	Sizes         map[string]int64    `json:"sizes"`
+	AppendBase    map[string]int64    `json:"append_base,omitempty"`
+	Counts        map[string]int      `json:"counts"`
+	RoutedBytes   map[string]int64    `json:"routed_bytes,omitempty"`
+	InputStats    []InputStat         `json:"input_stats,omitempty"`
+	Header        [][]byte            `json:"header,omitempty"`
+	RegionHeader  map[string][][]byte `json:"region_header,omitempty"`
+	HeaderWritten map[string]bool     `json:"header_written,omitempty"`
+	Parts         map[string]int      `json:"parts,omitempty"`
+	PartBytes     map[string]int64    `json:"part_bytes,omitempty"`
+	PartLines     map[string]int      `json:"part_lines,omitempty"`
+	Malformed     []MalformedLine     `json:"malformed_lines,omitempty"`
+	MalformedN    int                 `json:"malformed"`
+	OversizeN     int                 `json:"oversize"`
+	CommentN      int                 `json:"comments"`
+	FilteredN     int                 `json:"filtered"`
+	DroppedN      int                 `json:"dropped"`
+	FastaN        int                 `json:"fasta"`
+	JSONHeaderN   int                 `json:"json_header"`
+	Sampled       int                 `json:"sampled_unknown"`
+	SkipLeft      int                 `json:"skip_left"`
+	RoutedN       int                 `json:"routed_total"`
+	InFASTA       bool                `json:"in_fasta,omitempty"`
+	ChrIndex      int                 `json:"chr_index"`
+	PosIndex      int                 `json:"pos_index"`
+}
+
+// offsetScanner is a record scanner that knows how far into its input the
+// current record ends
+type offsetScanner interface {
+	recordScanner
+	Offset() int64
+}
+
+// checkCheckpointInputs rejects the runs a checkpoint cannot describe: the
+// inputs must be local uncompressed files read line by line on one
+// goroutine, so a resumed run can seek to where the last one stopped
+func (cp *ChromosomeProcessor) checkCheckpointInputs() error {
+	switch {
+	case cp.opts.DryRun:
+		return fmt.Errorf("a checkpoint cannot be combined with a dry run")
+	case cp.opts.Follow:
+		return fmt.Errorf("a followed input cannot be checkpointed")
+	case cp.opts.Workers > 1:
+		return fmt.Errorf("a checkpointed run needs a single worker")
+	case cp.opts.Format == FormatConcatJSON, cp.opts.Format == FormatPretty, cp.opts.Format == FormatJSONArray:
+		return fmt.Errorf("%s input cannot be checkpointed", cp.opts.Format)
+	case cp.opts.InputEncoding == EncodingUTF16LE, cp.opts.InputEncoding == EncodingUTF16BE:
+		return fmt.Errorf("UTF-16 input cannot be checkpointed")
+	}
+	for _, path := range cp.inputFiles {
+		switch {
+		case path == StdinInput, isHTTPInput(path), isS3Input(path), isGCSInput(path):
+			return fmt.Errorf("cannot checkpoint %s: only local files can be resumed", InputDisplayName(path))
+		case isTarInput(path), isZipInput(path):
+			return fmt.Errorf("cannot checkpoint %s: archive members cannot be resumed", path)
+		}
+		if compression := SniffCompression(path, cp.opts.InputCompression); compression != CompressionNone {
+			return fmt.Errorf("cannot checkpoint %s: %s input cannot be resumed, decompress it first", path, compression)
+		}
+	}
+	return nil
+}
+
+// checkpointPos records that the records of the current input are read up
+// to the end of the current one, and writes a checkpoint when one is due
+func (cp *ChromosomeProcessor) checkpointPos(scanner recordScanner, lineNum, routed int) error {
+	cp.ckpt.pos = checkpoint{Input: cp.ckpt.input, Offset: scanner.(offsetScanner).Offset(), Line: lineNum, Routed: routed}
+	cp.ckpt.since++
+	due := cp.opts.CheckpointLines > 0 && cp.ckpt.since >= cp.opts.CheckpointLines
+	if !due && cp.opts.CheckpointInterval > 0 && cp.ckpt.since%1024 == 0 {
+		due = time.Since(cp.ckpt.last) >= cp.opts.CheckpointInterval
+	}
+	if !due {
+		return nil
+	}
+	return cp.writeCheckpoint()
+}
+
+// writeCheckpoint closes the outputs, synced to disk, and records their
+// sizes with the current position in the Checkpoint file. The file is
+// replaced by a rename, so a crash leaves either the old checkpoint or the
+// new one. Until the first record the header is still being collected and
+// nothing is recorded.
+func (cp *ChromosomeProcessor) writeCheckpoint() error {
+	cp.ckpt.since = 0
+	cp.ckpt.last = time.Now()
+	if cp.tracksHeader() && !cp.hdr.done {
+		return nil
+	}
+
+	// closed outputs hold whole gzip members and zstd frames; they are
+	// reopened in append mode by the next record routed to them
+	if err := cp.CloseAllFiles(); err != nil {
+		return err
+	}
+	ck := cp.ckpt.pos
+	ck.Inputs = cp.inputFiles
+	ck.Sizes = make(map[string]int64, len(cp.out.created))
+	for name := range cp.out.created {
+		info, err := os.Stat(cp.TempPath(name))
+		if err != nil {
+			return fmt.Errorf("failed to checkpoint %s: %v", cp.TempPath(name), err)
+		}
+		ck.Sizes[name] = info.Size()
+	}
+	ck.AppendBase = cp.out.appendBase
+	ck.Counts, ck.RoutedBytes = cp.counts, cp.routedBytes
+	ck.InputStats = cp.inputStats
+	ck.Header, ck.RegionHeader, ck.HeaderWritten = cp.hdr.lines, cp.hdr.region, cp.hdr.written
+	ck.Parts, ck.PartBytes, ck.PartLines = cp.parts, cp.partBytes, cp.partLines
+	ck.Malformed, ck.MalformedN = cp.malformed, cp.tally.malformed
+	ck.OversizeN, ck.CommentN, ck.FilteredN, ck.DroppedN = cp.tally.oversize, cp.tally.comment, cp.tally.filtered, cp.tally.dropped
+	ck.FastaN, ck.JSONHeaderN, ck.Sampled = cp.tally.fasta, cp.tally.jsonHeader, cp.tally.unknownSampled
+	ck.SkipLeft, ck.RoutedN = cp.tally.skipLeft, cp.tally.routed
+	ck.InFASTA, ck.ChrIndex, ck.PosIndex = cp.inFASTA, cp.chrIndex, cp.posIndex
+
+	data, err := json.Marshal(ck)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %v", err)
+	}
+	if err := writeFileSynced(cp.opts.Checkpoint, data); err != nil {
+		return fmt.Errorf("failed to write checkpoint %s: %v", cp.opts.Checkpoint, err)
+	}
+	cp.logf("Checkpoint at %s byte %d: %d outputs", InputDisplayName(cp.inputFiles[ck.Input]), ck.Offset, len(ck.Sizes))
+	return nil
+}
+
+// writeFileSynced replaces path with data through a synced temporary file
+// and a rename, then syncs the directory so the rename is durable too
+func writeFileSynced(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// loadCheckpoint reads the Checkpoint file of the run to resume and restores
+// the routing state it recorded
+func (cp *ChromosomeProcessor) loadCheckpoint() error {
+	data, err := os.ReadFile(cp.opts.Checkpoint)
+	if err != nil {
+		return fmt.Errorf("cannot resume: %v", err)
+	}
+	var ck checkpoint
+	if err := json.Unmarshal(data, &ck); err != nil {
+		return fmt.Errorf("cannot resume: corrupt checkpoint %s: %v", cp.opts.Checkpoint, err)
+	}
+	if !slices.Equal(ck.Inputs, cp.inputFiles) {
+		return fmt.Errorf("cannot resume: the checkpoint %s is for other inputs (%v)", cp.opts.Checkpoint, ck.Inputs)
+	}
+
+	if ck.Counts != nil {
+		cp.counts = ck.Counts
+	}
+	if ck.RoutedBytes != nil {
+		cp.routedBytes = ck.RoutedBytes
+	}
+	cp.inputStats = ck.InputStats
+	cp.hdr.lines, cp.hdr.done = ck.Header, true
+	if ck.RegionHeader != nil {
+		cp.hdr.region = ck.RegionHeader
+	}
+	if ck.HeaderWritten != nil {
+		cp.hdr.written = ck.HeaderWritten
+	}
+	if ck.Parts != nil {
+		cp.parts, cp.partBytes, cp.partLines = ck.Parts, ck.PartBytes, ck.PartLines
+	}
+	cp.malformed, cp.tally.malformed = ck.Malformed, ck.MalformedN
+	cp.tally.oversize, cp.tally.comment, cp.tally.filtered, cp.tally.dropped = ck.OversizeN, ck.CommentN, ck.FilteredN, ck.DroppedN
+	cp.tally.fasta, cp.tally.jsonHeader, cp.tally.unknownSampled = ck.FastaN, ck.JSONHeaderN, ck.Sampled
+	cp.tally.skipLeft, cp.tally.routed = ck.SkipLeft, ck.RoutedN
+	cp.chrIndex, cp.posIndex = ck.ChrIndex, ck.PosIndex
+	cp.ckpt.resumeAt = &ck
+	return nil
+}
+
+// resumeOutputs truncates the outputs of the checkpoint back to their
+// recorded sizes, dropping whatever was written after it, and marks them
+// as created so they are appended to
+func (cp *ChromosomeProcessor) resumeOutputs() error {
+	ck := cp.ckpt.resumeAt
+	for name, size := range ck.Sizes {
+		path := cp.TempPath(name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("cannot resume: %v", err)
+		}
+		if info.Size() < size {
+			return fmt.Errorf("cannot resume: %s is shorter than at the checkpoint (%d < %d bytes)", path, info.Size(), size)
+		}
+		if err := os.Truncate(path, size); err != nil {
+			return fmt.Errorf("cannot resume: %v", err)
+		}
+		cp.out.created[name] = true
+	}
+	for name, size := range ck.AppendBase {
+		cp.out.appendBase[name] = size
+	}
+	cp.logf("Resuming at %s byte %d with %d outputs", InputDisplayName(cp.inputFiles[ck.Input]), ck.Offset, len(ck.Sizes))
+	return nil
+}
+
+// seekResumed moves the input being resumed to the end of the last record
+// the checkpoint covers. The offset of a text input counts the decoded text,
+// which a UTF-8 byte order mark precedes.
+func seekResumed(file *os.File, offset int64, text bool) error {
+	if !text {
+		_, err := file.Seek(offset, io.SeekStart)
+		return err
+	}
+	bom := make([]byte, len(utf8BOM))
+	n, err := io.ReadFull(file, bom)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if bytes.Equal(bom[:n], utf8BOM) {
+		offset += int64(len(utf8BOM))
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	return err
+}
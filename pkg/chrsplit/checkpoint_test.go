@@ -0,0 +1,130 @@
+package chrsplit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// stopAfterCheckpoints is a Logger output that stops a run once it has
+// written n checkpoints
+type stopAfterCheckpoints struct {
+	cp *ChromosomeProcessor
+	n  int
+}
+
+func (s *stopAfterCheckpoints) Write(p []byte) (int, error) {
+	if bytes.HasPrefix(p, []byte("Checkpoint at")) {
+		if s.n--; s.n == 0 {
+			s.cp.Stop()
+		}
+	}
+	return len(p), nil
+}
+
+func TestCheckpointResume(t *testing.T) {
+	records := testRecords(20000)
+	for _, bom := range []bool{false, true} {
+		input := writeInput(t, "in.jsonl", records, "\n")
+		if bom {
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(input, append(slices.Clone(utf8BOM), data...), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := runSplit(t, []string{input}, Options{})
+
+		dir := t.TempDir()
+		opts := Options{OutputDir: dir, Checkpoint: filepath.Join(dir, "ck"), CheckpointLines: 3000}
+		stopper := &stopAfterCheckpoints{n: 2}
+		opts.Logger = log.New(stopper, "", 0)
+		cp := NewChromosomeProcessor([]string{input}, "out", "chr", testChromosomes, opts)
+		stopper.cp = cp
+		if err := cp.ProcessFile(); !errors.Is(err, ErrInterrupted) {
+			t.Fatalf("bom %v: interrupted run: got %v, want %v", bom, err, ErrInterrupted)
+		}
+
+		// lines written after the checkpoint, as a crash would leave them,
+		// are truncated on resume
+		partial, err := os.OpenFile(cp.TempPath("chr1"), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(partial, records[0]+"\n"+`{"chr":"chr1","cut`); err != nil {
+			t.Fatal(err)
+		}
+		partial.Close()
+
+		opts.Logger, opts.Resume = nil, true
+		resumed := runSplit(t, []string{input}, opts)
+		for _, chr := range want.OutputChromosomes() {
+			if got, want := readOutput(t, resumed, chr), readOutput(t, want, chr); !slices.Equal(got, want) {
+				t.Errorf("bom %v: %s after resuming: got %d records, want %d as in an uninterrupted run", bom, chr, len(got), len(want))
+			}
+		}
+		if _, err := os.Stat(opts.Checkpoint); !os.IsNotExist(err) {
+			t.Errorf("bom %v: the checkpoint is left after the run succeeded: %v", bom, err)
+		}
+	}
+}
+
+func TestResumeOutputsTruncates(t *testing.T) {
+	dir := t.TempDir()
+	cp := NewChromosomeProcessor([]string{"in.jsonl"}, "out", "chr", testChromosomes, Options{OutputDir: dir})
+	cp.reset()
+	if err := os.WriteFile(cp.TempPath("chr1"), []byte("kept\nlost\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cp.ckpt.resumeAt = &checkpoint{Sizes: map[string]int64{"chr1": 5}}
+	if err := cp.resumeOutputs(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(cp.TempPath("chr1")); string(data) != "kept\n" {
+		t.Errorf("after resumeOutputs: got %q, want %q", data, "kept\n")
+	}
+	if !cp.out.created["chr1"] {
+		t.Errorf("a resumed output is not marked as created, so it would be replaced")
+	}
+
+	cp.ckpt.resumeAt = &checkpoint{Sizes: map[string]int64{"chr1": 100}}
+	if err := cp.resumeOutputs(); err == nil || !strings.Contains(err.Error(), "shorter than at the checkpoint") {
+		t.Errorf("resuming an output shorter than its checkpoint: got %v", err)
+	}
+}
+
+func TestSeekResumed(t *testing.T) {
+	for _, test := range []struct {
+		data   string
+		offset int64
+		text   bool
+		want   string
+	}{
+		{"a\nbb\n", 2, true, "bb\n"},
+		{"\xef\xbb\xbfa\nbb\n", 2, true, "bb\n"},  // the offset counts the text after the BOM
+		{"\xef\xbb\xbfa\nbb\n", 5, false, "bb\n"}, // raw offsets count every byte
+		{"a\n", 2, true, ""},
+		{"", 0, true, ""},
+	} {
+		path := filepath.Join(t.TempDir(), "in.jsonl")
+		if err := os.WriteFile(path, []byte(test.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		file := mustOpen(t, path)
+		if err := seekResumed(file, test.offset, test.text); err != nil {
+			t.Fatalf("%q at %d: %v", test.data, test.offset, err)
+		}
+		if rest, _ := io.ReadAll(file); string(rest) != test.want {
+			t.Errorf("%q at %d (text %v): read %q, want %q", test.data, test.offset, test.text, rest, test.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package chrsplit
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultChromosomes returns the default list of chromosome names
+func DefaultChromosomes() []string {
+	chroms := make([]string, 0, 25)
+
+	// chr1-chr22
+	for i := 1; i <= 22; i++ {
+		chroms = append(chroms, fmt.Sprintf("chr%d", i))
+	}
+
+	// chrX, chrY, chrM
+	chroms = append(chroms, "chrX", "chrY", "chrM")
+
+	return chroms
+}
+
+// ParseChromosomeNames parses the comma-separated chromosome names string,
+// falling back to DefaultChromosomes when it is empty
+func ParseChromosomeNames(chrNamesStr string) []string {
+	if chrNamesStr == "" {
+		return DefaultChromosomes()
+	}
+
+	parts := strings.Split(chrNamesStr, ",")
+	chrNames := make([]string, 0, len(parts))
+
+	for _, part := range parts {
+		name := strings.TrimSpace(part)
+		if name != "" {
+			chrNames = append(chrNames, name)
+		}
+	}
+
+	return chrNames
+}
+
+// SortChromosomes returns a copy of chrs in natural chromosome order (see
+// naturalChrLess)
+func SortChromosomes(chrs []string) []string {
+	sorted := slices.Clone(chrs)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		switch {
+		case naturalChrLess(a, b):
+			return -1
+		case naturalChrLess(b, a):
+			return 1
+		}
+		return 0
+	})
+	return sorted
+}
+
+// naturalChrLess orders chromosome names naturally: numbered chromosomes by
+// number (chr2 before chr10, with or without the chr prefix), then X, Y and
+// M (or MT), then every other name alphabetically. Whatever follows the
+// number or letter, e.g. the bin of chr1_bin0000123, breaks ties.
+func naturalChrLess(a, b string) bool {
+	ra, na, resta := chrRank(a)
+	rb, nb, restb := chrRank(b)
+	switch {
+	case ra != rb:
+		return ra < rb
+	case na != nb:
+		return na < nb
+	case resta != restb:
+		return resta < restb
+	}
+	return a < b
+}
+
+// chrRank splits a chromosome name for naturalChrLess into its class (0
+// numbered, 1 sex or mitochondrial, 2 other), its number within the class
+// and the rest of the name
+func chrRank(chr string) (int, int, string) {
+	name := chr
+	if len(name) >= 3 && strings.EqualFold(name[:3], "chr") {
+		name = name[3:]
+	}
+	digits := 0
+	for digits < len(name) && name[digits] >= '0' && name[digits] <= '9' {
+		digits++
+	}
+	if digits > 0 {
+		if n, err := strconv.Atoi(name[:digits]); err == nil {
+			return 0, n, name[digits:]
+		}
+	}
+	for i, special := range []string{"X", "Y", "MT", "M"} {
+		if len(name) >= len(special) && strings.EqualFold(name[:len(special)], special) &&
+			(len(name) == len(special) || !isAlphanumeric(name[len(special)])) {
+			return 1, min(i, 2), name[len(special):]
+		}
+	}
+	return 2, 0, chr
+}
+
+// isAlphanumeric reports whether c is an ASCII letter or digit
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// DefaultMitoAliases are the names of the mitochondrial chromosome
+// recognized by Normalize
+var DefaultMitoAliases = []string{"M", "MT"}
+
+// normalizeChromosome strips a leading "chr" (in any case), upper-cases the
+// rest and maps mitochondrial aliases to "M", so that "chr1", "1" and "CHR1"
+// (or "chrM" and "MT") compare equal
+func normalizeChromosome(chr string, mitoAliases []string) string {
+	if len(chr) >= 3 && strings.EqualFold(chr[:3], "chr") {
+		chr = chr[3:]
+	}
+	chr = strings.ToUpper(chr)
+	for _, alias := range mitoAliases {
+		if strings.EqualFold(chr, alias) {
+			return "M"
+		}
+	}
+	return chr
+}
+
+// aliasKey returns the lookup key of a chromosome value in the alias table
+func aliasKey(chr string, opts Options) string {
+	if opts.Normalize {
+		return normalizeChromosome(chr, opts.MitoAliases)
+	}
+	if opts.IgnoreCase {
+		return strings.ToLower(chr)
+	}
+	return chr
+}
+
+// buildAliases maps the alias key of every target chromosome to its
+// canonical name, or returns nil when no alias matching is enabled. The first
+// target wins when two of them normalize (or lower-case) to the same key.
+func buildAliases(chrNames []string, opts Options) map[string]string {
+	if !opts.Normalize && !opts.IgnoreCase {
+		return nil
+	}
+	aliases := make(map[string]string, len(chrNames))
+	for _, chr := range chrNames {
+		key := aliasKey(chr, opts)
+		if _, exists := aliases[key]; !exists {
+			aliases[key] = chr
+		}
+	}
+	return aliases
+}
@@ -0,0 +1,138 @@
+package chrsplit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// commentChr routes a comment line, which is counted but not written, and
+// headerChr a line starting with the HeaderPrefix option. They cannot clash
+// with an output name, as '#' never survives dynamicOutputName.
+const (
+	commentChr = "#comment"
+	headerChr  = "#header"
+)
+
+// what --keep-comments keeps of the comment lines
+const (
+	KeepCommentsNone   = "none"
+	KeepCommentsHeader = "header"
+)
+
+// ValidateKeepComments checks a --keep-comments value
+func ValidateKeepComments(keep string) error {
+	switch keep {
+	case KeepCommentsNone, KeepCommentsHeader:
+		return nil
+	}
+	return fmt.Errorf("unsupported --keep-comments value %q (expected none or header)", keep)
+}
+
+// isComment reports whether line starts with one of the comment prefixes
+func (cp *ChromosomeProcessor) isComment(line []byte) bool {
+	for _, prefix := range cp.opts.CommentPrefixes {
+		if prefix != "" && bytes.HasPrefix(line, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// tracksHeader reports whether header or comment lines are looked for
+func (cp *ChromosomeProcessor) tracksHeader() bool {
+	return len(cp.opts.CommentPrefixes) > 0 || cp.opts.HeaderPrefix != "" ||
+		cp.opts.JSONHeaderLines > 0 || cp.opts.HeaderTypeField != "" || cp.isText()
+}
+
+// trackHeader collects the header replicated into every output: the lines
+// before the first record of the run that start with the HeaderPrefix
+// option or are header records (see JSONHeaderLines and HeaderTypeField),
+// and the comment lines among them with KeepCommentHeader. Once the
+// first record shows up, the header is written to the outputs created so
+// far; later outputs get it when they are opened. Header lines after the
+// first record are skipped like comments.
+func (cp *ChromosomeProcessor) trackHeader(chr string, line []byte) error {
+	switch {
+	case chr == regionChr && !cp.hdr.done:
+		cp.addRegion(line)
+	case chr == fastaStartChr, chr == FastaChr:
+	case chr == headerChr && !cp.hdr.done:
+		cp.hdr.lines = append(cp.hdr.lines, append([]byte(nil), line...))
+	case chr == headerChr, chr == regionChr, chr == commentChr:
+		cp.tally.comment++
+		if chr == commentChr && cp.opts.KeepCommentHeader && !cp.hdr.done {
+			cp.hdr.lines = append(cp.hdr.lines, append([]byte(nil), line...))
+		}
+	case !cp.hdr.done:
+		return cp.finishHeader()
+	}
+	return nil
+}
+
+// finishHeader ends header collection and writes the header to every output
+// created so far, reopening those evicted from the open file pool
+func (cp *ChromosomeProcessor) finishHeader() error {
+	cp.hdr.done = true
+	if !cp.hasHeader() || cp.opts.DryRun {
+		return nil
+	}
+	for name := range cp.out.created {
+		if cp.hdr.written[name] {
+			continue
+		}
+		writer, err := cp.outputWriter(name)
+		if err != nil {
+			return err
+		}
+		if err := cp.writeHeader(name, writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasHeader reports whether a header was collected
+func (cp *ChromosomeProcessor) hasHeader() bool {
+	return len(cp.hdr.lines) > 0 || len(cp.hdr.region) > 0
+}
+
+// writeHeader writes the header to an output, once, followed by the GFF3
+// sequence regions of that output; the FASTA sidecar gets none
+func (cp *ChromosomeProcessor) writeHeader(chr string, writer *bufio.Writer) error {
+	if cp.hdr.written[chr] || chr == FastaChr {
+		return nil
+	}
+	cp.hdr.written[chr] = true
+	lines := append(cp.hdr.lines[:len(cp.hdr.lines):len(cp.hdr.lines)], cp.hdr.region[chr]...)
+	for _, line := range lines {
+		writer.Write(line)
+		if err := writer.WriteByte(cp.recordDelim()); err != nil {
+			return fmt.Errorf("failed to write comment header: %v", err)
+		}
+		cp.indexHeader(chr, len(line)+1)
+		cp.routedBytes[chr] += int64(len(line) + 1)
+	}
+	return nil
+}
+
+// HeaderRecords returns the lines of the header collected in the last
+// ProcessFile call that are JSON records, e.g. a metadata object
+func (cp *ChromosomeProcessor) HeaderRecords() []json.RawMessage {
+	var records []json.RawMessage
+	for _, line := range cp.hdr.lines {
+		if gjson.ValidBytes(line) {
+			records = append(records, json.RawMessage(line))
+		}
+	}
+	return records
+}
+
+// Comments returns the number of comment lines skipped in the last
+// ProcessFile call
+func (cp *ChromosomeProcessor) Comments() int {
+	return cp.tally.comment
+}
@@ -0,0 +1,191 @@
+package chrsplit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionBGZF is the --compress value for BGZF (bgzip) outputs, gzip
+// files made of independent blocks that tabix can index
+const CompressionBGZF = "bgzf"
+
+// compressWriter is the compression layer of an output, between its buffer
+// and its file
+type compressWriter interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// ValidateOutputCompression checks a --compress value and its level (0 for
+// the default of the compression)
+func ValidateOutputCompression(mode string, level int) error {
+	switch mode {
+	case CompressionNone:
+		if level != 0 {
+			return fmt.Errorf("a compression level needs gzip or zstd output compression")
+		}
+	case CompressionGzip, CompressionBGZF:
+		if level < 0 || level > gzip.BestCompression {
+			return fmt.Errorf("invalid %s level %d (expected 1-9)", mode, level)
+		}
+	case CompressionZstd:
+		if level < 0 || level > 22 {
+			return fmt.Errorf("invalid zstd level %d (expected 1-22)", level)
+		}
+	default:
+		return fmt.Errorf("unsupported output compression %q (expected none, gzip, bgzf or zstd)", mode)
+	}
+	return nil
+}
+
+// CompressionForName returns the output compression a file name asks for
+// by its last extension, in any case: .gz for gzip, .bgz for BGZF, .zst for
+// zstd and anything else for none, so "out.v2.JSONL.GZ" is gzip
+func CompressionForName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz":
+		return CompressionGzip
+	case ".bgz":
+		return CompressionBGZF
+	case ".zst":
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+// outputCompression returns the compression of the outputs: the Compress
+// option, else gzip with the Gzip option
+func (cp *ChromosomeProcessor) outputCompression() string {
+	switch {
+	case cp.opts.Compress != "":
+		return cp.opts.Compress
+	case cp.opts.Gzip:
+		return CompressionGzip
+	}
+	return CompressionNone
+}
+
+// compressExt returns the extension the output compression adds
+func (cp *ChromosomeProcessor) compressExt() string {
+	switch cp.outputCompression() {
+	case CompressionGzip, CompressionBGZF:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// OutputBytes returns the number of uncompressed bytes routed to the
+// outputs in the last ProcessFile call and the number of bytes the outputs
+// grew by on disk, which differ for compressed outputs. It must be called
+// after ProcessFile returned, so the output sizes are final.
+func (cp *ChromosomeProcessor) OutputBytes() (routed, written int64) {
+	for _, n := range cp.routedBytes {
+		routed += n
+	}
+	for name := range cp.out.created {
+		if info, err := os.Stat(cp.OutputPath(name)); err == nil {
+			written += info.Size() - cp.out.appendBase[name]
+		}
+	}
+	return routed, written
+}
+
+// CompressionRatio returns the uncompressed bytes routed to the output of
+// chr (all its parts) per byte written to disk, and false for uncompressed
+// outputs and outputs that were not written. It must be called after
+// ProcessFile returned.
+func (cp *ChromosomeProcessor) CompressionRatio(chr string) (float64, bool) {
+	if cp.outputCompression() == CompressionNone {
+		return 0, false
+	}
+	var routed, written int64
+	for n := 1; n <= max(cp.parts[chr], 1); n++ {
+		name := partKey(chr, n)
+		if !cp.out.created[name] {
+			continue
+		}
+		if info, err := os.Stat(cp.OutputPath(name)); err == nil {
+			routed += cp.routedBytes[name]
+			written += info.Size() - cp.out.appendBase[name]
+		}
+	}
+	if routed == 0 || written <= 0 {
+		return 0, false
+	}
+	return float64(routed) / float64(written), true
+}
+
+// newCompressWriter returns the compression layer writing to an output
+// file, or nil for uncompressed outputs. With CompressThreads > 1, gzip
+// and zstd outputs are written as independent members or frames of
+// compressBlockSize bytes compressed in parallel.
+func (cp *ChromosomeProcessor) newCompressWriter(w io.Writer) (compressWriter, error) {
+	level := cp.opts.CompressLevel
+	threads := max(cp.opts.CompressThreads, 1)
+	switch cp.outputCompression() {
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if threads > 1 {
+			return newBlockWriter(w, compressBlockSize, threads, nil, func() (blockEncoder, error) {
+				zw, err := gzip.NewWriterLevel(nil, level)
+				return &gzipEncoder{zw: zw}, err
+			})
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CompressionBGZF:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return newBGZFWriter(w, level, threads)
+	case CompressionZstd:
+		// zero frames, so an output without records is still a valid (empty)
+		// zstd file rather than a zero-byte one
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1), zstd.WithZeroFrames(true)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		if threads > 1 {
+			return newBlockWriter(w, compressBlockSize, threads, nil, func() (blockEncoder, error) {
+				enc, err := zstd.NewWriter(nil, opts...)
+				return &zstdEncoder{enc: enc}, err
+			})
+		}
+		return zstd.NewWriter(w, opts...)
+	}
+	return nil, nil
+}
+
+// gzipEncoder compresses one block into a gzip member
+type gzipEncoder struct {
+	zw *gzip.Writer
+}
+
+func (e *gzipEncoder) encode(dst *bytes.Buffer, src []byte) error {
+	e.zw.Reset(dst)
+	if _, err := e.zw.Write(src); err != nil {
+		return err
+	}
+	return e.zw.Close()
+}
+
+// zstdEncoder compresses one block into a zstd frame
+type zstdEncoder struct {
+	enc *zstd.Encoder
+}
+
+func (e *zstdEncoder) encode(dst *bytes.Buffer, src []byte) error {
+	dst.Write(e.enc.EncodeAll(src, dst.AvailableBuffer()))
+	return nil
+}
@@ -0,0 +1,76 @@
+package chrsplit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompressionForName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"{prefix}_{chr}.jsonl.gz", CompressionGzip},
+		{"a.b.jsonl.gz", CompressionGzip},
+		{"out.v2.JSONL.GZ", CompressionGzip},
+		{"{chr}.split.jsonl.Gz", CompressionGzip},
+		{"{chr}.jsonl.zst", CompressionZstd},
+		{"{chr}.split.jsonl.ZST", CompressionZstd},
+		{"{chr}.vcf.bgz", CompressionBGZF},
+		{"{chr}.vcf.BGZ", CompressionBGZF},
+		{"{chr}.split.jsonl", CompressionNone},
+		{"{chr}.gz.jsonl", CompressionNone},
+		{"gz/{chr}.jsonl", CompressionNone},
+		{"batch.gz/{chr}.jsonl", CompressionNone},
+		{"{chr}", CompressionNone},
+		{"{chr}.tgz", CompressionNone},
+		{"{chr}.jsonl.bz2", CompressionNone},
+	}
+	for _, tt := range tests {
+		if got := CompressionForName(tt.name); got != tt.want {
+			t.Errorf("CompressionForName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// byteCounter counts the bytes written to it
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// BenchmarkCompressLevel compresses the same records at levels 1 and 9,
+// reporting the compression ratio next to the throughput
+func BenchmarkCompressLevel(b *testing.B) {
+	data := []byte(strings.Join(testRecords(50000), "\n") + "\n")
+	for _, compression := range []string{CompressionGzip, CompressionBGZF, CompressionZstd} {
+		for _, threads := range []int{1, 4} {
+			for _, level := range []int{1, 9} {
+				b.Run(fmt.Sprintf("%s/threads=%d/level=%d", compression, threads, level), func(b *testing.B) {
+					cp := NewChromosomeProcessor(nil, "out", "chr", testChromosomes, Options{
+						Compress: compression, CompressLevel: level, CompressThreads: threads,
+					})
+					var written byteCounter
+					b.SetBytes(int64(len(data)))
+					for b.Loop() {
+						written = 0
+						zw, err := cp.newCompressWriter(&written)
+						if err != nil {
+							b.Fatal(err)
+						}
+						if _, err := zw.Write(data); err != nil {
+							b.Fatal(err)
+						}
+						if err := zw.Close(); err != nil {
+							b.Fatal(err)
+						}
+					}
+					b.ReportMetric(float64(len(data))/float64(written), "ratio")
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package chrsplit
+
+import (
+	"hash/maphash"
+
+	"github.com/tidwall/gjson"
+)
+
+// isDuplicate reports whether a record of chr was routed before in this
+// run, remembering it otherwise. Records are compared by a 64-bit hash of
+// the whole line, or of the DedupField value; a record without that field is
+// never a duplicate.
+func (cp *ChromosomeProcessor) isDuplicate(chr string, record []byte) bool {
+	key := record
+	if cp.opts.DedupField != "" {
+		result := gjson.GetBytes(record, cp.opts.DedupField)
+		if !result.Exists() {
+			return false
+		}
+		key = []byte(result.Raw)
+	}
+	seen, ok := cp.dedupSets[chr]
+	if !ok {
+		seen = make(map[uint64]struct{})
+		cp.dedupSets[chr] = seen
+	}
+	h := maphash.Bytes(cp.dedupSeed, key)
+	if _, dup := seen[h]; dup {
+		cp.tally.dedup++
+		return true
+	}
+	seen[h] = struct{}{}
+	return false
+}
+
+// Deduplicated returns the number of duplicate records skipped with the
+// Dedup option in the last ProcessFile call
+func (cp *ChromosomeProcessor) Deduplicated() int {
+	return cp.tally.dedup
+}
@@ -0,0 +1,112 @@
+package chrsplit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+)
+
+// FormatCSV reads comma-separated values, quoted as in RFC 4180 (a quoted
+// field may span lines). Like FormatTSV input, the chromosome is taken from
+// the ChrColumn of the header row, which is replicated into every output, or
+// from the ChrColumnIndex of a headerless file; outputs are named .csv.
+const FormatCSV = "csv"
+
+// isDelimited reports whether the inputs are TSV or CSV
+func (cp *ChromosomeProcessor) isDelimited() bool {
+	return cp.opts.Format == FormatTSV || cp.opts.Format == FormatCSV
+}
+
+// delimiter returns the field delimiter of TSV or CSV input
+func (cp *ChromosomeProcessor) delimiter() byte {
+	switch {
+	case cp.opts.Delimiter != 0:
+		return cp.opts.Delimiter
+	case cp.opts.Format == FormatCSV:
+		return ','
+	}
+	return '\t'
+}
+
+// csvFields splits a CSV record into its unquoted fields
+func (cp *ChromosomeProcessor) csvFields(line []byte) ([]string, bool) {
+	r := csv.NewReader(bytes.NewReader(line))
+	r.Comma = rune(cp.delimiter())
+	r.FieldsPerRecord = -1
+	fields, err := r.Read()
+	return fields, err == nil
+}
+
+// readHeaderRow takes the first row of a TSV or CSV input as its header and
+// finds the chromosome column, and the position column for BinSize, by name
+func (cp *ChromosomeProcessor) readHeaderRow(line []byte, name string) error {
+	var columns []string
+	if cp.opts.Format == FormatCSV {
+		columns, _ = cp.csvFields(line)
+	} else {
+		for _, col := range bytes.Split(line, []byte{cp.delimiter()}) {
+			columns = append(columns, string(col))
+		}
+	}
+
+	cp.chrIndex, cp.posIndex = -1, -1
+	for i, column := range columns {
+		switch column {
+		case cp.opts.ChrColumn:
+			if cp.chrIndex < 0 {
+				cp.chrIndex = i
+			}
+		case cp.opts.PosFieldName:
+			if cp.posIndex < 0 {
+				cp.posIndex = i
+			}
+		}
+	}
+	if cp.chrIndex < 0 {
+		return fmt.Errorf("column %q not found in the header row of %s", cp.opts.ChrColumn, name)
+	}
+	return nil
+}
+
+// csvReader splits a CSV stream into records, joining the lines of a quoted
+// field that spans several of them
+type csvReader struct {
+	lr     *lineReader
+	record []byte
+}
+
+// Scan advances to the next record, which is then available through Bytes
+func (cr *csvReader) Scan() bool {
+	if !cr.lr.Scan() {
+		return false
+	}
+	line := cr.lr.Bytes()
+	if bytes.Count(line, []byte{'"'})%2 == 0 {
+		cr.record = line
+		return true
+	}
+
+	// an odd number of quotes leaves a quoted field open
+	record := append([]byte(nil), line...)
+	for bytes.Count(record, []byte{'"'})%2 != 0 && cr.lr.Scan() {
+		record = append(record, '\n')
+		record = append(record, cr.lr.Bytes()...)
+	}
+	cr.record = record
+	return true
+}
+
+// Bytes returns the current record. It is only valid until the next Scan.
+func (cr *csvReader) Bytes() []byte {
+	return cr.record
+}
+
+// Err returns the read error that ended scanning, if any
+func (cr *csvReader) Err() error {
+	return cr.lr.Err()
+}
+
+// Offset returns the number of bytes read up to the end of the current record
+func (cr *csvReader) Offset() int64 {
+	return cr.lr.Offset()
+}
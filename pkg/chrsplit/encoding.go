@@ -0,0 +1,112 @@
+package chrsplit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+// input text encodings accepted by --input-encoding
+const (
+	EncodingAuto    = "auto"
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+)
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+
+	errUnpairedSurrogate = errors.New("invalid UTF-16: unpaired surrogate")
+	errOddUTF16Length    = errors.New("invalid UTF-16: odd number of bytes")
+)
+
+// ValidateInputEncoding checks a --input-encoding value
+func ValidateInputEncoding(encoding string) error {
+	switch encoding {
+	case EncodingAuto, EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE:
+		return nil
+	}
+	return fmt.Errorf("unsupported input encoding %q (expected auto, utf-8, utf-16le or utf-16be)", encoding)
+}
+
+// decodeText returns r transcoded to UTF-8. A byte order mark at the start
+// selects the encoding and is dropped; without one, encoding decides, and
+// "auto" means UTF-8.
+func decodeText(r io.Reader, encoding string) io.Reader {
+	br := bufio.NewReaderSize(r, 64*1024)
+	bom, _ := br.Peek(3)
+
+	switch {
+	case bytes.HasPrefix(bom, utf8BOM):
+		br.Discard(len(utf8BOM))
+		return br
+	case bytes.HasPrefix(bom, utf16LEBOM):
+		br.Discard(len(utf16LEBOM))
+		encoding = EncodingUTF16LE
+	case bytes.HasPrefix(bom, utf16BEBOM):
+		br.Discard(len(utf16BEBOM))
+		encoding = EncodingUTF16BE
+	}
+
+	switch encoding {
+	case EncodingUTF16LE:
+		return transform.NewReader(br, utf16Decoder{binary.LittleEndian})
+	case EncodingUTF16BE:
+		return transform.NewReader(br, utf16Decoder{binary.BigEndian})
+	}
+	return br
+}
+
+// utf16Decoder transcodes UTF-16 to UTF-8. Unlike the x/text decoders it
+// fails on an unpaired surrogate instead of substituting U+FFFD, so a
+// damaged export is not split silently.
+type utf16Decoder struct {
+	order binary.ByteOrder
+}
+
+func (d utf16Decoder) Reset() {}
+
+func (d utf16Decoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc+1 < len(src) {
+		r, size := rune(d.order.Uint16(src[nSrc:])), 2
+		if utf16.IsSurrogate(r) {
+			if r >= 0xdc00 {
+				return nDst, nSrc, errUnpairedSurrogate
+			}
+			if nSrc+3 >= len(src) {
+				if atEOF {
+					return nDst, nSrc, errUnpairedSurrogate
+				}
+				return nDst, nSrc, transform.ErrShortSrc
+			}
+			if r = utf16.DecodeRune(r, rune(d.order.Uint16(src[nSrc+2:]))); r == utf8.RuneError {
+				return nDst, nSrc, errUnpairedSurrogate
+			}
+			size = 4
+		}
+
+		if nDst+utf8.RuneLen(r) > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += utf8.EncodeRune(dst[nDst:], r)
+		nSrc += size
+	}
+
+	if nSrc < len(src) {
+		if atEOF {
+			return nDst, nSrc, errOddUTF16Length
+		}
+		return nDst, nSrc, transform.ErrShortSrc
+	}
+	return nDst, nSrc, nil
+}
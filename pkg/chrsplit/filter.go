@@ -0,0 +1,60 @@
+package chrsplit
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// filteredChr routes a record rejected by the filter, which is counted but
+// not written
+const filteredChr = "#filtered"
+
+// comparisons accepted by --filter-op
+const (
+	FilterEq = "eq"
+	FilterNe = "ne"
+	FilterGt = "gt"
+	FilterLt = "lt"
+)
+
+// ValidateFilterOp checks a --filter-op value
+func ValidateFilterOp(op string) error {
+	switch op {
+	case FilterEq, FilterNe, FilterGt, FilterLt:
+		return nil
+	}
+	return fmt.Errorf("unsupported filter op %q (expected eq, ne, gt or lt)", op)
+}
+
+// matchesFilter reports whether a record passes the filter. gt and lt
+// compare numbers (a record whose field is not a number fails them); eq and
+// ne compare numbers when both sides are numbers, and strings otherwise.
+func (cp *ChromosomeProcessor) matchesFilter(line []byte) bool {
+	result := gjson.GetBytes(line, cp.opts.FilterField)
+	value, err := strconv.ParseFloat(cp.opts.FilterValue, 64)
+	numeric := err == nil && result.Type == gjson.Number
+
+	switch cp.opts.FilterOp {
+	case FilterGt:
+		return numeric && result.Num > value
+	case FilterLt:
+		return numeric && result.Num < value
+	case FilterNe:
+		if numeric {
+			return result.Num != value
+		}
+		return !result.Exists() || result.String() != cp.opts.FilterValue
+	}
+	if numeric {
+		return result.Num == value
+	}
+	return result.Exists() && result.String() == cp.opts.FilterValue
+}
+
+// Filtered returns the number of records dropped by the filter in the last
+// ProcessFile call
+func (cp *ChromosomeProcessor) Filtered() int {
+	return cp.tally.filtered
+}
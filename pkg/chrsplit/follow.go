@@ -0,0 +1,106 @@
+package chrsplit
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// followPollInterval is how often a followed file is checked for new data
+const followPollInterval = 250 * time.Millisecond
+
+// followReader reads a file that is still being written, like `tail -f`. At
+// the end of the file it polls for more data instead of returning io.EOF, and
+// it only hands out complete lines, so a line whose newline has not been
+// written yet is held back. It stops at the sentinel line (which is not
+// delivered), after idle passes without new data, or when stop is closed.
+type followReader struct {
+	file     io.ReadCloser
+	sentinel []byte
+	idle     time.Duration
+	stop     <-chan struct{}
+	chunk    []byte
+	partial  []byte
+	lines    []byte
+	lastData time.Time
+	done     bool
+}
+
+func newFollowReader(file io.ReadCloser, sentinel string, idle time.Duration, stop <-chan struct{}) *followReader {
+	f := &followReader{
+		file:     file,
+		idle:     idle,
+		stop:     stop,
+		chunk:    make([]byte, 64*1024),
+		lastData: time.Now(),
+	}
+	if sentinel != "" {
+		f.sentinel = []byte(sentinel)
+	}
+	return f
+}
+
+func (f *followReader) Read(p []byte) (int, error) {
+	for len(f.lines) == 0 {
+		if f.done {
+			return 0, io.EOF
+		}
+
+		n, err := f.file.Read(f.chunk)
+		if n > 0 {
+			f.lastData = time.Now()
+			f.partial = append(f.partial, f.chunk[:n]...)
+			f.takeLines()
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		// at the end of the file for now: wait for the writer
+		if f.idle > 0 && time.Since(f.lastData) >= f.idle {
+			f.done = true
+			continue
+		}
+		select {
+		case <-f.stop:
+			f.done = true
+		case <-time.After(followPollInterval):
+		}
+	}
+
+	n := copy(p, f.lines)
+	f.lines = f.lines[n:]
+	return n, nil
+}
+
+// takeLines moves the complete lines read so far from partial to lines,
+// stopping at the sentinel line
+func (f *followReader) takeLines() {
+	end := bytes.LastIndexByte(f.partial, '\n') + 1
+	if end == 0 {
+		return
+	}
+	complete := f.partial[:end]
+
+	if f.sentinel != nil {
+		for start := 0; start < len(complete); {
+			next := start + bytes.IndexByte(complete[start:], '\n') + 1
+			if bytes.Equal(bytes.TrimRight(complete[start:next], "\r\n"), f.sentinel) {
+				f.lines = append(f.lines, complete[:start]...)
+				f.partial = nil
+				f.done = true
+				return
+			}
+			start = next
+		}
+	}
+
+	f.lines = append(f.lines, complete...)
+	f.partial = append(f.partial[:0], f.partial[end:]...)
+}
+
+// Close closes the followed file
+func (f *followReader) Close() error {
+	return f.file.Close()
+}
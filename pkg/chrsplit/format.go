@@ -0,0 +1,264 @@
+package chrsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// input record formats accepted by --format
+const (
+	FormatJSONL      = "jsonl"
+	FormatConcatJSON = "concat-json"
+	FormatJSONArray  = "json-array"
+	FormatJSONSeq    = "json-seq"
+	FormatPretty     = "pretty"
+)
+
+// recordSeparator starts every record of an RFC 7464 JSON text sequence
+const recordSeparator = 0x1e
+
+// ValidateFormat checks a --format value
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatJSONL, FormatConcatJSON, FormatJSONArray, FormatJSONSeq, FormatPretty, FormatVCF, FormatBED, FormatGFF, FormatSAM, FormatTSV, FormatCSV, FormatMsgpack:
+		return nil
+	}
+	return fmt.Errorf("unsupported input format %q (expected jsonl, concat-json, json-array, json-seq, pretty, vcf, bed, gff, sam, tsv, csv or msgpack)", format)
+}
+
+// ValidateOutputFormat checks a --output-format value
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case FormatJSONL, FormatJSONSeq, FormatTSV:
+		return nil
+	}
+	return fmt.Errorf("unsupported output format %q (expected jsonl, json-seq or tsv)", format)
+}
+
+// recordScanner reads one record at a time: a line, a JSON value of a
+// concatenated stream or an element of a JSON array
+type recordScanner interface {
+	Scan() bool
+	Bytes() []byte
+	Err() error
+}
+
+// newRecordScanner returns the scanner for the given input format. JSONL
+// input starting with a record separator is read as a JSON text sequence.
+func newRecordScanner(r io.Reader, format string) recordScanner {
+	switch format {
+	case FormatConcatJSON:
+		return newConcatReader(r, false)
+	case FormatPretty:
+		return newConcatReader(r, true)
+	case FormatJSONArray:
+		return newArrayReader(r)
+	case FormatMsgpack:
+		return newMsgpackReader(r)
+	}
+
+	lr := newLineReader(r)
+	switch format {
+	case FormatVCF, FormatBED, FormatGFF, FormatSAM, FormatTSV:
+		return lr
+	case FormatCSV:
+		return &csvReader{lr: lr}
+	}
+	if first, _ := lr.br.Peek(1); format == FormatJSONSeq || (len(first) == 1 && first[0] == recordSeparator) {
+		lr.delim = recordSeparator
+		return &seqReader{lr: lr}
+	}
+	return lr
+}
+
+// RecordUnit names what the line counts of Stats and InputStats count:
+// "lines" for JSONL input, "records" for the JSON formats, where a record
+// may span several lines or share one
+func (cp *ChromosomeProcessor) RecordUnit() string {
+	if cp.opts.Format == "" || cp.opts.Format == FormatJSONL || cp.isText() {
+		return "lines"
+	}
+	return "records"
+}
+
+// recordPos describes the position of the nth record of an input (counted
+// from 1) in messages: a line number, or the index of a JSON array element
+func (cp *ChromosomeProcessor) recordPos(n int) string {
+	switch cp.opts.Format {
+	case FormatConcatJSON, FormatJSONSeq, FormatPretty, FormatMsgpack:
+		return fmt.Sprintf("record %d", n)
+	case FormatJSONArray:
+		return fmt.Sprintf("element %d", n-1)
+	}
+	return fmt.Sprintf("line %d", n)
+}
+
+// concatReader splits a stream of back-to-back JSON values, with or without
+// whitespace (blank lines included) between them, into records. A value
+// spanning several lines is compacted onto one, so it is written out as a
+// single JSONL line; with minify every value is compacted. Braces inside
+// strings do not confuse it, as the values are tokenised by encoding/json.
+type concatReader struct {
+	dec     *json.Decoder
+	raw     json.RawMessage
+	record  []byte
+	compact bytes.Buffer
+	minify  bool
+	err     error
+}
+
+func newConcatReader(r io.Reader, minify bool) *concatReader {
+	return &concatReader{dec: json.NewDecoder(r), minify: minify}
+}
+
+// Scan advances to the next JSON value, which is then available through
+// Bytes. It returns false at the end of the input or on a read or syntax
+// error; the stream cannot be resynchronised after a syntax error.
+func (cr *concatReader) Scan() bool {
+	cr.raw = cr.raw[:0]
+	if err := cr.dec.Decode(&cr.raw); err != nil {
+		if err != io.EOF {
+			cr.err = err
+		}
+		return false
+	}
+
+	cr.record = cr.raw
+	if cr.minify || bytes.ContainsAny(cr.raw, "\r\n") {
+		cr.compact.Reset()
+		json.Compact(&cr.compact, cr.raw)
+		cr.record = cr.compact.Bytes()
+	}
+	return true
+}
+
+// Bytes returns the current record. It is only valid until the next Scan.
+func (cr *concatReader) Bytes() []byte {
+	return cr.record
+}
+
+// Err returns the error that ended scanning, if any
+func (cr *concatReader) Err() error {
+	return cr.err
+}
+
+// seqReader reads an RFC 7464 JSON text sequence, where every record starts
+// with a record separator (0x1E) and ends with a newline. The framing is
+// stripped, and a record spanning several lines is compacted onto one.
+type seqReader struct {
+	lr     *lineReader
+	record []byte
+	buf    bytes.Buffer
+}
+
+// Scan advances to the next non-empty record
+func (sr *seqReader) Scan() bool {
+	for sr.lr.Scan() {
+		record := bytes.TrimSpace(sr.lr.Bytes())
+		if len(record) == 0 {
+			continue
+		}
+		sr.record = record
+		if bytes.ContainsAny(record, "\r\n") {
+			// invalid JSON cannot be compacted, but must still end up on one line
+			sr.buf.Reset()
+			if json.Compact(&sr.buf, record) != nil {
+				sr.buf.Reset()
+				sr.buf.Write(bytes.Map(func(r rune) rune {
+					if r == '\r' || r == '\n' {
+						return ' '
+					}
+					return r
+				}, record))
+			}
+			sr.record = sr.buf.Bytes()
+		}
+		return true
+	}
+	return false
+}
+
+// Bytes returns the current record. It is only valid until the next Scan.
+func (sr *seqReader) Bytes() []byte {
+	return sr.record
+}
+
+// Err returns the read error that ended scanning, if any
+func (sr *seqReader) Err() error {
+	return sr.lr.Err()
+}
+
+// Offset returns the number of bytes read up to the end of the current record
+func (sr *seqReader) Offset() int64 {
+	return sr.lr.Offset()
+}
+
+// arrayReader streams the elements of a top-level JSON array, decoding one
+// element at a time so memory stays flat however large the array is. Each
+// element is minified onto one line.
+type arrayReader struct {
+	dec     *json.Decoder
+	raw     json.RawMessage
+	record  bytes.Buffer
+	started bool
+	err     error
+}
+
+func newArrayReader(r io.Reader) *arrayReader {
+	return &arrayReader{dec: json.NewDecoder(r)}
+}
+
+// Scan advances to the next array element, which is then available through
+// Bytes. It returns false after the closing bracket or on an error; content
+// after the array is an error too.
+func (ar *arrayReader) Scan() bool {
+	if ar.err != nil {
+		return false
+	}
+	if !ar.started {
+		ar.started = true
+		tok, err := ar.dec.Token()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			ar.err = err
+			return false
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			ar.err = fmt.Errorf("input is not a JSON array (starts with %v)", tok)
+			return false
+		}
+	}
+
+	if !ar.dec.More() {
+		// the closing bracket, which must end the input
+		if _, err := ar.dec.Token(); err != nil {
+			ar.err = err
+		} else if _, err := ar.dec.Token(); err != io.EOF {
+			ar.err = fmt.Errorf("unexpected content after the JSON array")
+		}
+		return false
+	}
+
+	ar.raw = ar.raw[:0]
+	if err := ar.dec.Decode(&ar.raw); err != nil {
+		ar.err = err
+		return false
+	}
+	ar.record.Reset()
+	json.Compact(&ar.record, ar.raw)
+	return true
+}
+
+// Bytes returns the current element. It is only valid until the next Scan.
+func (ar *arrayReader) Bytes() []byte {
+	return ar.record.Bytes()
+}
+
+// Err returns the error that ended scanning, if any
+func (ar *arrayReader) Err() error {
+	return ar.err
+}
@@ -0,0 +1,81 @@
+package chrsplit
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// FormatGFF reads GFF3 or GTF: the # comment and directive lines are the
+// header, except that a ##sequence-region line only goes to the output of its
+// sequence, and the ##FASTA trailer is copied to a FastaChr sidecar. Columns
+// are tab-separated; outputs are named .gff3.
+const FormatGFF = "gff"
+
+// FastaChr is the output of the ##FASTA section of GFF3 inputs, written as
+// prefix_fasta.fa
+const FastaChr = "fasta"
+
+// regionChr routes a ##sequence-region directive and fastaStartChr the
+// ##FASTA directive of a GFF3 input
+const (
+	regionChr     = "#region"
+	fastaStartChr = "#fasta"
+)
+
+var (
+	gffRegionDirective = []byte("##sequence-region")
+	gffFASTADirective  = []byte("##FASTA")
+)
+
+// isGFF reports whether the inputs are GFF3 or GTF
+func (cp *ChromosomeProcessor) isGFF() bool {
+	return cp.opts.Format == FormatGFF
+}
+
+// routeGFFDirective returns the route of the GFF3 directives handled apart
+// from the other header lines, or "" for any other line
+func routeGFFDirective(line []byte) string {
+	switch {
+	case bytes.HasPrefix(line, gffRegionDirective):
+		return regionChr
+	case bytes.Equal(bytes.TrimSpace(line), gffFASTADirective):
+		return fastaStartChr
+	}
+	return ""
+}
+
+// addRegion keeps a ##sequence-region line for the header of the output of
+// its sequence; one without a sequence name goes to every output
+func (cp *ChromosomeProcessor) addRegion(line []byte) {
+	line = append([]byte(nil), line...)
+	fields := bytes.Fields(line)
+	if len(fields) < 2 {
+		cp.hdr.lines = append(cp.hdr.lines, line)
+		return
+	}
+	target := cp.outputFor(string(fields[1]))
+	cp.hdr.region[target] = append(cp.hdr.region[target], line)
+}
+
+// writeFASTA copies a line of a ##FASTA section to the FastaChr sidecar
+func (cp *ChromosomeProcessor) writeFASTA(line []byte) error {
+	cp.tally.fasta++
+	if cp.opts.DryRun {
+		return nil
+	}
+	writer, err := cp.outputWriter(FastaChr)
+	if err != nil {
+		return err
+	}
+	writer.Write(line)
+	if err := writer.WriteByte('\n'); err != nil {
+		return fmt.Errorf("failed to write FASTA sidecar: %v", err)
+	}
+	return nil
+}
+
+// FASTALines returns the number of ##FASTA section lines copied to the
+// FastaChr sidecar in the last ProcessFile call
+func (cp *ChromosomeProcessor) FASTALines() int {
+	return cp.tally.fasta
+}
@@ -0,0 +1,102 @@
+package chrsplit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testChromosomes are the target chromosomes of the test runs
+var testChromosomes = []string{"chr1", "chr2", "chrX"}
+
+// testRecords returns n JSONL records spread over testChromosomes and an
+// unknown chromosome
+func testRecords(n int) []string {
+	chrs := append(slices.Clone(testChromosomes), "chrUn")
+	records := make([]string, n)
+	for i := range records {
+		records[i] = fmt.Sprintf(`{"chr":"%s","pos":%d,"id":"rs%d"}`, chrs[i%len(chrs)], i+1, i)
+	}
+	return records
+}
+
+// writeInput writes lines, each ending with eol, to name in a temporary
+// directory and returns its path
+func writeInput(t testing.TB, name string, lines []string, eol string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString(eol)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runSplit splits inputs into testChromosomes with opts, writing to a
+// temporary directory unless opts names one
+func runSplit(t testing.TB, inputs []string, opts Options) *ChromosomeProcessor {
+	t.Helper()
+	if opts.OutputDir == "" {
+		opts.OutputDir = t.TempDir()
+	}
+	cp := NewChromosomeProcessor(inputs, "out", "chr", testChromosomes, opts)
+	if err := cp.ProcessFile(); err != nil {
+		t.Fatalf("ProcessFile: %v", err)
+	}
+	return cp
+}
+
+// readOutput returns the records written to the output of chr
+func readOutput(t testing.TB, cp *ChromosomeProcessor, chr string) []string {
+	t.Helper()
+	data, err := os.ReadFile(cp.OutputPath(chr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// readOutputs returns the records of every output, in no particular order
+func readOutputs(t testing.TB, cp *ChromosomeProcessor) []string {
+	t.Helper()
+	var records []string
+	for _, chr := range cp.OutputChromosomes() {
+		if _, err := os.Stat(cp.OutputPath(chr)); os.IsNotExist(err) {
+			continue
+		}
+		scanner := bufio.NewScanner(mustOpen(t, cp.OutputPath(chr)))
+		scanner.Buffer(nil, 1<<30)
+		for scanner.Scan() {
+			records = append(records, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return records
+}
+
+// mustOpen opens path, closing it when the test ends
+func mustOpen(t testing.TB, path string) *os.File {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// sameRecords reports whether got and want hold the same records, ignoring
+// their order
+func sameRecords(got, want []string) bool {
+	return slices.Equal(slices.Sorted(slices.Values(got)), slices.Sorted(slices.Values(want)))
+}
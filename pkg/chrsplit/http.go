@@ -0,0 +1,167 @@
+package chrsplit
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// HTTPTokenEnv is the environment variable holding an optional bearer token
+// sent with HTTP(S) input requests
+const HTTPTokenEnv = "CHRSPLIT_HTTP_TOKEN"
+
+// isHTTPInput reports whether the input is an http:// or https:// URL
+func isHTTPInput(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// IsRemoteInput reports whether the input is read over the network rather
+// than from the local file system
+func IsRemoteInput(path string) bool {
+	return isHTTPInput(path) || isS3Input(path) || isGCSInput(path)
+}
+
+// httpStatusError is a non-success HTTP response
+type httpStatusError struct {
+	status string
+	code   int
+}
+
+func (e *httpStatusError) Error() string {
+	return "unexpected HTTP status " + e.status
+}
+
+// retryable reports whether the request may succeed when sent again
+func (e *httpStatusError) retryable() bool {
+	return e.code == http.StatusTooManyRequests || e.code >= 500
+}
+
+// httpReader streams a URL and, when the connection breaks, resumes with a
+// Range request from the last byte it delivered
+type httpReader struct {
+	client   *http.Client
+	url      string
+	token    string
+	retries  int
+	failures int
+	offset   int64
+	body     io.ReadCloser
+}
+
+// openHTTP starts streaming url. The timeout bounds connecting and waiting for
+// response headers, not the whole (possibly hours long) transfer.
+func openHTTP(url string, timeout time.Duration, retries int) (*httpReader, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if timeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: timeout}).DialContext
+		transport.TLSHandshakeTimeout = timeout
+		transport.ResponseHeaderTimeout = timeout
+	}
+
+	h := &httpReader{
+		client:  &http.Client{Transport: transport},
+		url:     url,
+		token:   os.Getenv(HTTPTokenEnv),
+		retries: retries,
+	}
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// connect sends the request, retrying transient failures with backoff
+func (h *httpReader) connect() error {
+	for {
+		err := h.request()
+		if err == nil {
+			return nil
+		}
+		if se, ok := err.(*httpStatusError); ok && !se.retryable() {
+			return err
+		}
+		if h.failures >= h.retries {
+			return fmt.Errorf("giving up after %d retries: %v", h.retries, err)
+		}
+		h.failures++
+		time.Sleep(time.Duration(h.failures) * time.Second)
+	}
+}
+
+func (h *httpReader) request() error {
+	req, err := http.NewRequest(http.MethodGet, h.url, nil)
+	if err != nil {
+		return err
+	}
+	// ask for the bytes as stored, so offsets stay valid for Range requests
+	req.Header.Set("Accept-Encoding", "identity")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	if h.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", h.offset))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case h.offset == 0 && resp.StatusCode == http.StatusOK:
+	case h.offset > 0 && resp.StatusCode == http.StatusPartialContent:
+	case h.offset > 0 && resp.StatusCode == http.StatusOK:
+		resp.Body.Close()
+		return fmt.Errorf("cannot resume at byte %d: server ignored the Range request", h.offset)
+	default:
+		resp.Body.Close()
+		return &httpStatusError{status: resp.Status, code: resp.StatusCode}
+	}
+
+	h.body = resp.Body
+	return nil
+}
+
+func (h *httpReader) Read(p []byte) (int, error) {
+	for {
+		if h.body == nil {
+			if err := h.connect(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := h.body.Read(p)
+		h.offset += int64(n)
+		if n > 0 {
+			h.failures = 0
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		// the connection broke mid-stream: drop it and resume from offset
+		// on the next attempt
+		h.body.Close()
+		h.body = nil
+		if n > 0 {
+			return n, nil
+		}
+		if h.failures >= h.retries {
+			return 0, fmt.Errorf("giving up at byte %d after %d retries: %v", h.offset, h.retries, err)
+		}
+		h.failures++
+		time.Sleep(time.Duration(h.failures) * time.Second)
+	}
+}
+
+// Close closes the current connection
+func (h *httpReader) Close() error {
+	if h.body == nil {
+		return nil
+	}
+	return h.body.Close()
+}
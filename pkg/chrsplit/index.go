@@ -0,0 +1,112 @@
+package chrsplit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// outputIndex is the position index of one output being written: its size
+// so far, the range of its positions and every IndexEvery-th record
+type outputIndex struct {
+	offset  int64
+	records int
+	minPos  int64
+	maxPos  int64
+	hasPos  bool
+	samples []indexSample
+}
+
+// indexSample locates one record of an output; pos is -1 for a record
+// without a position
+type indexSample struct {
+	pos    int64
+	offset int64
+	record int
+}
+
+// indexFor returns the index of an output, starting it if needed
+func (cp *ChromosomeProcessor) indexFor(name string) *outputIndex {
+	idx, ok := cp.indexes[name]
+	if !ok {
+		idx = &outputIndex{}
+		cp.indexes[name] = idx
+	}
+	return idx
+}
+
+// indexHeader accounts for header bytes written at the top of an output
+func (cp *ChromosomeProcessor) indexHeader(name string, size int) {
+	if cp.opts.IndexEvery > 0 {
+		cp.indexFor(name).offset += int64(size)
+	}
+}
+
+// indexRecord adds a record about to be written to an output, taking size
+// bytes there, to its index; pos is its position, if it has one
+func (cp *ChromosomeProcessor) indexRecord(name string, pos int64, ok bool, size int) {
+	idx := cp.indexFor(name)
+	if ok {
+		if !idx.hasPos || pos < idx.minPos {
+			idx.minPos = pos
+		}
+		if !idx.hasPos || pos > idx.maxPos {
+			idx.maxPos = pos
+		}
+		idx.hasPos = true
+	} else {
+		pos = -1
+	}
+	if idx.records%cp.opts.IndexEvery == 0 {
+		idx.samples = append(idx.samples, indexSample{pos: pos, offset: idx.offset, record: idx.records})
+	}
+	idx.records++
+	idx.offset += int64(size)
+}
+
+// IndexPath returns the path of the index file of an output
+func (cp *ChromosomeProcessor) IndexPath(chr string) string {
+	return cp.OutputPath(chr) + ".idx"
+}
+
+// writeIndexes writes the index file of every output created in this run
+// next to it. An index is tab-separated: comment lines with the output, its
+// record count and position range, then one line per sampled record with
+// its position ("." when it has none), byte offset and record number, in
+// file order.
+func (cp *ChromosomeProcessor) writeIndexes() error {
+	for name := range cp.out.created {
+		if err := cp.writeIndex(name, cp.indexFor(name)); err != nil {
+			return fmt.Errorf("failed to write index %s: %v", cp.IndexPath(name), err)
+		}
+	}
+	return nil
+}
+
+// writeIndex writes the index file of one output
+func (cp *ChromosomeProcessor) writeIndex(name string, idx *outputIndex) error {
+	file, err := os.Create(cp.IndexPath(name))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	fmt.Fprintf(w, "# output=%s records=%d every=%d\n", filepath.Base(cp.OutputPath(name)), idx.records, cp.opts.IndexEvery)
+	if idx.hasPos {
+		fmt.Fprintf(w, "# min_pos=%d max_pos=%d\n", idx.minPos, idx.maxPos)
+	}
+	fmt.Fprintf(w, "#pos\toffset\trecord\n")
+	for _, s := range idx.samples {
+		pos := "."
+		if s.pos >= 0 {
+			pos = strconv.FormatInt(s.pos, 10)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\n", pos, s.offset, s.record)
+	}
+	err = w.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,406 @@
+package chrsplit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// input compression modes accepted by --input-compression
+const (
+	CompressionAuto  = "auto"
+	CompressionNone  = "none"
+	CompressionGzip  = "gzip"
+	CompressionZstd  = "zstd"
+	CompressionBzip2 = "bzip2"
+	CompressionXz    = "xz"
+)
+
+// StdinInput is the input path that selects standard input
+const StdinInput = "-"
+
+var (
+	// gzipMagic is the two-byte header every gzip stream starts with
+	gzipMagic = []byte{0x1f, 0x8b}
+	// zstdMagic is the frame magic number 0xFD2FB528 in little-endian order
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	// bzip2Magic is "BZh", followed by the block size digit
+	bzip2Magic = []byte("BZh")
+	// xzMagic is the six-byte xz stream header magic
+	xzMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// magicLen is the number of leading bytes needed to recognize every format
+const magicLen = 6
+
+// inputReader is the (possibly decompressed) input stream together with
+// everything that has to be closed once reading is done
+type inputReader struct {
+	io.Reader
+	raw *countingReader
+	// mapped is the line reader of a memory-mapped input (see Options.Mmap)
+	mapped  *mmapReader
+	closers []io.Closer
+}
+
+// countingReader counts the raw bytes read from an input source, and adds
+// them to total as well when it is set
+type countingReader struct {
+	r     io.Reader
+	n     int64
+	total *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.total != nil {
+		c.total.Add(int64(n))
+	}
+	return n, err
+}
+
+// Close closes the decompressor and the underlying file in reverse order
+func (ir *inputReader) Close() error {
+	var firstErr error
+	for i := len(ir.closers) - 1; i >= 0; i-- {
+		if err := ir.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// decompressErrorReader turns low-level decompression errors into a clear
+// message, so a truncated archive is not mistaken for a short input
+type decompressErrorReader struct {
+	r      io.Reader
+	format string
+}
+
+func (d decompressErrorReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("corrupt or truncated %s stream: %v", d.format, err)
+	}
+	return n, err
+}
+
+// ValidateInputCompression checks a --input-compression value
+func ValidateInputCompression(mode string) error {
+	switch mode {
+	case CompressionAuto, CompressionNone, CompressionGzip, CompressionZstd, CompressionBzip2, CompressionXz:
+		return nil
+	}
+	return fmt.Errorf("unsupported input compression %q (expected auto, gzip, zstd, bzip2, xz or none)", mode)
+}
+
+// detectCompression resolves the compression of an input from the requested
+// mode, the file extension and the leading magic bytes
+func detectCompression(mode, path string, magic []byte) string {
+	if mode != CompressionAuto && mode != "" {
+		return mode
+	}
+	if strings.HasSuffix(path, ".gz") || bytes.HasPrefix(magic, gzipMagic) {
+		return CompressionGzip
+	}
+	if strings.HasSuffix(path, ".zst") || bytes.HasPrefix(magic, zstdMagic) {
+		return CompressionZstd
+	}
+	if strings.HasSuffix(path, ".bz2") || bytes.HasPrefix(magic, bzip2Magic) {
+		return CompressionBzip2
+	}
+	if strings.HasSuffix(path, ".xz") || bytes.HasPrefix(magic, xzMagic) {
+		return CompressionXz
+	}
+	return CompressionNone
+}
+
+// unsupportedFormats are compressions recognized, by file extension or
+// magic bytes, only to fail clearly instead of splitting compressed bytes
+var unsupportedFormats = []struct {
+	name  string
+	ext   string
+	magic []byte
+}{
+	{"lz4", ".lz4", []byte{0x04, 0x22, 0x4d, 0x18}},
+	{"compress (LZW)", ".Z", []byte{0x1f, 0x9d}},
+	{"lzip", ".lz", []byte("LZIP")},
+	{"lzma", ".lzma", nil},
+	{"brotli", ".br", nil},
+	{"snappy", ".sz", []byte("\xff\x06\x00\x00sNaPpY")},
+	{"7-Zip", ".7z", []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+	{"lzop", ".lzo", []byte{0x89, 'L', 'Z', 'O', 0x00}},
+}
+
+// unsupportedCompression returns the name of an unsupported compression
+// recognized from the file extension or the leading magic bytes, or ""
+func unsupportedCompression(path string, magic []byte) string {
+	for _, format := range unsupportedFormats {
+		if strings.HasSuffix(path, format.ext) || format.magic != nil && bytes.HasPrefix(magic, format.magic) {
+			return format.name
+		}
+	}
+	return ""
+}
+
+// SniffCompression reports the compression openInput would pick for path,
+// without consuming any input. Stdin, remote inputs and non-regular files
+// (named pipes, process substitution like <(zcat a.gz)) cannot be peeked
+// ahead of time, so their format is only known once reading starts.
+func SniffCompression(path, mode string) string {
+	if mode != CompressionAuto && mode != "" {
+		return mode
+	}
+	if path == StdinInput || IsRemoteInput(path) || !isRegularFile(path) {
+		return "auto (detected on read)"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return mode
+	}
+	defer file.Close()
+
+	header := make([]byte, bgzfHeaderLen)
+	n, _ := io.ReadFull(file, header)
+	compression := detectCompression(mode, path, header[:n])
+	if _, ok := bgzfBlockSize(header[:n]); ok && compression == CompressionGzip {
+		return "gzip (bgzf)"
+	}
+	return compression
+}
+
+// isRegularFile reports whether path is a regular file, which can be opened
+// more than once and read from the start each time. Pipes and character
+// devices are read exactly once, front to back, until EOF.
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// InputDisplayName returns the name used for an input in messages
+func InputDisplayName(path string) string {
+	if path == StdinInput {
+		return "stdin"
+	}
+	return path
+}
+
+// ExpandInputs expands glob patterns among the inputs (in lexical order) and
+// keeps plain paths as they are. A pattern matching no file is an error, so a
+// typo does not silently produce empty outputs.
+func ExpandInputs(inputs []string) ([]string, error) {
+	expanded := make([]string, 0, len(inputs))
+	for _, input := range inputs {
+		if input == StdinInput || IsRemoteInput(input) || !strings.ContainsAny(input, "*?[") {
+			expanded = append(expanded, input)
+			continue
+		}
+
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input pattern %q: %v", input, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("input pattern %q matches no files", input)
+		}
+		sort.Strings(matches)
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+// ReadInputList reads a text file listing one input path per line. Blank
+// lines and lines starting with '#' are ignored, relative paths are resolved
+// against the directory of the list file, and every path is checked up front
+// so a typo fails the run before any work is done. Glob patterns are kept for
+// ExpandInputs, which fails on a pattern matching no file.
+func ReadInputList(listPath string) ([]string, error) {
+	file, err := os.Open(listPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input list: %v", err)
+	}
+	defer file.Close()
+
+	baseDir := filepath.Dir(listPath)
+	var inputs, missing []string
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		if !filepath.IsAbs(entry) {
+			entry = filepath.Join(baseDir, entry)
+		}
+		if strings.ContainsAny(entry, "*?[") {
+			inputs = append(inputs, entry)
+			continue
+		}
+		if _, err := os.Stat(entry); err != nil {
+			missing = append(missing, fmt.Sprintf("%s:%d: %v", listPath, lineNum, err))
+			continue
+		}
+		inputs = append(inputs, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read input list %s: %v", listPath, err)
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%d input(s) in the list are not readable:\n  %s", len(missing), strings.Join(missing, "\n  "))
+	}
+	return inputs, nil
+}
+
+// openSource opens the raw (possibly compressed) bytes of an input: stdin,
+// an HTTP(S) URL, an S3 or GCS object or a local file
+func (cp *ChromosomeProcessor) openSource(path string) (io.ReadCloser, error) {
+	switch {
+	case path == StdinInput:
+		return os.Stdin, nil
+	case isHTTPInput(path):
+		return openHTTP(path, cp.opts.HTTPTimeout, cp.opts.HTTPRetries)
+	case isS3Input(path):
+		return openS3(path)
+	case isGCSInput(path):
+		return openGCS(path, cp.opts.GCSReadChunkSize)
+	}
+
+	// the open file is checked rather than the path, so a named pipe or
+	// /dev/fd input is opened exactly once and read front to back
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, fmt.Errorf("is a directory (use --recursive)")
+	}
+	if !cp.opts.Follow {
+		return file, nil
+	}
+	return newFollowReader(file, cp.opts.FollowSentinel, cp.opts.IdleTimeout, cp.stop), nil
+}
+
+// openInput opens an input and wraps it with the decompressor selected by
+// the InputCompression option ("auto" sniffs the extension and magic bytes)
+func (cp *ChromosomeProcessor) openInput(path string) (*inputReader, error) {
+	if cp.opts.Mmap && !cp.opts.Follow {
+		if input := cp.openMapped(path); input != nil {
+			return input, nil
+		}
+	}
+	src, err := cp.openSource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = src
+	if cp.opts.RangeEnd > 0 {
+		rr, err := newRangeReader(src.(*os.File), cp.opts.RangeStart, cp.opts.RangeEnd, cp.recordDelim())
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		r = rr
+	}
+	raw := &countingReader{r: r, total: &cp.progressBytes}
+	if ck := cp.ckpt.resumeAt; ck != nil && ck.Input == cp.ckpt.input && ck.Offset > 0 {
+		if err := seekResumed(src.(*os.File), ck.Offset, !cp.isMsgpack()); err != nil {
+			src.Close()
+			return nil, fmt.Errorf("failed to seek to the checkpoint: %v", err)
+		}
+		raw.n = ck.Offset
+	}
+	if cp.opts.Follow {
+		// a followed file is plain text: peeking for magic bytes would
+		// hold back a short first line until more data arrives
+		return &inputReader{Reader: raw, raw: raw, closers: []io.Closer{src}}, nil
+	}
+	input, err := decompress(raw, path, cp.opts.InputCompression, cp.opts.DecompressThreads)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	input.raw = raw
+	input.closers = append([]io.Closer{src}, input.closers...)
+	return input, nil
+}
+
+// decompress wraps the raw input with the decompressor selected by mode. BGZF
+// input is inflated block by block on threads goroutines.
+func decompress(raw io.Reader, path, mode string, threads int) (*inputReader, error) {
+	br := bufio.NewReaderSize(raw, 64*1024)
+	magic, _ := br.Peek(magicLen)
+
+	if mode == CompressionAuto || mode == "" {
+		if name := unsupportedCompression(path, magic); name != "" {
+			return nil, fmt.Errorf("%s compression is not supported (only gzip, zstd, bzip2 and xz are): decompress the input first, or use --input-compression none to read it as is", name)
+		}
+	}
+
+	switch detectCompression(mode, path, magic) {
+	case CompressionGzip:
+		// BGZF (bgzip) files are independent gzip blocks that can be
+		// inflated in parallel; plain gzip falls through to gzip.Reader
+		header, _ := br.Peek(bgzfHeaderLen)
+		if _, ok := bgzfBlockSize(header); ok {
+			zr := newBGZFReader(br, threads)
+			return &inputReader{Reader: decompressErrorReader{zr, "bgzf"}, closers: []io.Closer{zr}}, nil
+		}
+
+		// gzip.Reader is in multistream mode by default, so concatenated
+		// members (as written by bgzip or `cat a.gz b.gz`) are read through
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip header: %v", err)
+		}
+		return &inputReader{Reader: decompressErrorReader{zr, "gzip"}, closers: []io.Closer{zr}}, nil
+
+	case CompressionZstd:
+		// the decoder streams frame by frame, so memory stays bounded by the
+		// window size no matter how large the decompressed content is
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd decoder: %v", err)
+		}
+		rc := zr.IOReadCloser()
+		return &inputReader{Reader: decompressErrorReader{rc, "zstd"}, closers: []io.Closer{rc}}, nil
+
+	case CompressionBzip2:
+		ar := newAsyncReader(decompressErrorReader{bzip2.NewReader(br), "bzip2"})
+		return &inputReader{Reader: ar, closers: []io.Closer{ar}}, nil
+
+	case CompressionXz:
+		zr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read xz header: %v", err)
+		}
+		ar := newAsyncReader(decompressErrorReader{zr, "xz"})
+		return &inputReader{Reader: ar, closers: []io.Closer{ar}}, nil
+	}
+
+	return &inputReader{Reader: br}, nil
+}
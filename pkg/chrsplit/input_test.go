@@ -0,0 +1,56 @@
+package chrsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// pipeInput returns the /dev/fd path of a pipe fed with records, the way
+// process substitution passes one to a command
+func pipeInput(t *testing.T, records []string) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	path := fmt.Sprintf("/dev/fd/%d", r.Fd())
+	if _, err := os.Stat(path); err != nil {
+		w.Close()
+		t.Skipf("no /dev/fd: %v", err)
+	}
+	go func() {
+		defer w.Close()
+		for _, record := range records {
+			if _, err := io.WriteString(w, record+"\n"); err != nil {
+				return
+			}
+		}
+	}()
+	return path
+}
+
+func TestPipeInput(t *testing.T) {
+	records := testRecords(20000)
+	for _, opts := range []Options{{Workers: 1}, {Workers: 4}, {Workers: 1, Mmap: true}} {
+		input := pipeInput(t, records)
+		opts.OutputDir = t.TempDir()
+		cp := NewChromosomeProcessor([]string{input}, "out", "chr", testChromosomes, opts)
+		if err := cp.ProcessFile(); err != nil {
+			t.Fatalf("workers %d, mmap %v: %v", opts.Workers, opts.Mmap, err)
+		}
+		if got := readOutputs(t, cp); !sameRecords(got, records) {
+			t.Errorf("workers %d, mmap %v: got %d records, want %d", opts.Workers, opts.Mmap, len(got), len(records))
+		}
+	}
+}
+
+func TestDirectoryInput(t *testing.T) {
+	cp := NewChromosomeProcessor([]string{t.TempDir()}, "out", "chr", testChromosomes, Options{OutputDir: t.TempDir()})
+	if err := cp.ProcessFile(); err == nil || !strings.Contains(err.Error(), "use --recursive") {
+		t.Fatalf("ProcessFile of a directory: got %v, want a --recursive hint", err)
+	}
+}
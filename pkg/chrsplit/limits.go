@@ -0,0 +1,24 @@
+package chrsplit
+
+// skipLine reports whether a line read is one of the first SkipLines of the
+// run, which are discarded before routing
+func (cp *ChromosomeProcessor) skipLine() bool {
+	if cp.tally.skipLeft > 0 {
+		cp.tally.skipLeft--
+		return true
+	}
+	return false
+}
+
+// routedLine counts a routed line towards MaxLines and reports whether the
+// limit is now reached
+func (cp *ChromosomeProcessor) routedLine() bool {
+	cp.tally.routed++
+	return cp.atLineLimit()
+}
+
+// atLineLimit reports whether MaxLines lines were routed, which ends the run
+// like the end of the inputs
+func (cp *ChromosomeProcessor) atLineLimit() bool {
+	return cp.opts.MaxLines > 0 && cp.tally.routed >= cp.opts.MaxLines
+}
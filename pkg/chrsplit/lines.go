@@ -0,0 +1,85 @@
+package chrsplit
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	// lineBufferSize is the read buffer; lines up to this size are returned
+	// straight from it without copying
+	lineBufferSize = 64 * 1024
+	// maxRetainedLine is the largest long-line buffer kept for reuse, so one
+	// huge record does not pin its memory for the rest of the run
+	maxRetainedLine = 16 * 1024 * 1024
+)
+
+// lineReader splits a stream into lines like bufio.Scanner with ScanLines,
+// but without a maximum line length: a line longer than the read buffer is
+// assembled in a buffer that grows as needed. Unlike bufio.Scanner, a line cut
+// short by a read error is not returned. A JSON text sequence is read with
+// the record separator as delim instead of the newline.
+type lineReader struct {
+	br     *bufio.Reader
+	delim  byte
+	long   []byte
+	line   []byte
+	offset int64
+	err    error
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{br: bufio.NewReaderSize(r, lineBufferSize), delim: '\n'}
+}
+
+// Scan advances to the next line, which is then available through Bytes. It
+// returns false at the end of the input or on a read error.
+func (lr *lineReader) Scan() bool {
+	if cap(lr.long) > maxRetainedLine {
+		lr.long = nil
+	}
+
+	line, err := lr.br.ReadSlice(lr.delim)
+	if err == bufio.ErrBufferFull {
+		lr.long = append(lr.long[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = lr.br.ReadSlice(lr.delim)
+			lr.long = append(lr.long, line...)
+		}
+		line = lr.long
+	}
+
+	if err != nil && err != io.EOF {
+		lr.err = err
+		return false
+	}
+	if len(line) == 0 {
+		return false
+	}
+	lr.offset += int64(len(line))
+
+	// drop the newline and a carriage return before it
+	if line[len(line)-1] == lr.delim {
+		line = line[:len(line)-1]
+	}
+	if lr.delim == '\n' && len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	lr.line = line
+	return true
+}
+
+// Bytes returns the current line. It is only valid until the next Scan.
+func (lr *lineReader) Bytes() []byte {
+	return lr.line
+}
+
+// Err returns the read error that ended scanning, if any
+func (lr *lineReader) Err() error {
+	return lr.err
+}
+
+// Offset returns the number of bytes read up to the end of the current line
+func (lr *lineReader) Offset() int64 {
+	return lr.offset
+}
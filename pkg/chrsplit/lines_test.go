@@ -0,0 +1,96 @@
+package chrsplit
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var crlfTests = []struct {
+	name  string
+	input string
+	want  []string
+}{
+	{"crlf", "a\r\nb\r\n", []string{"a", "b"}},
+	{"no final newline", "a\r\nb", []string{"a", "b"}},
+	{"final carriage return", "a\r\nb\r", []string{"a", "b"}},
+	{"blank line", "a\r\n\r\nb\r\n", []string{"a", "", "b"}},
+	{"mixed", "a\nb\r\nc\n", []string{"a", "b", "c"}},
+	{"inner carriage return", "a\rb\r\n", []string{"a\rb"}},
+}
+
+// scanAll returns the lines of scanner
+func scanAll(t *testing.T, scanner recordScanner) []string {
+	t.Helper()
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, string(scanner.Bytes()))
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestLineReaderCRLF(t *testing.T) {
+	for _, tt := range crlfTests {
+		if got := scanAll(t, newLineReader(strings.NewReader(tt.input))); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMmapReaderCRLF(t *testing.T) {
+	for _, tt := range crlfTests {
+		mr := &mmapReader{data: []byte(tt.input), raw: &countingReader{}}
+		if got := scanAll(t, mr); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCRLF(t *testing.T) {
+	records := testRecords(1000)
+	input := writeInput(t, "in.jsonl", records, "\r\n")
+	for _, mmap := range []bool{false, true} {
+		var logged bytes.Buffer
+		cp := runSplit(t, []string{input}, Options{Mmap: mmap, Logger: log.New(&logged, "", 0)})
+		if strings.Contains(logged.String(), "Not mapping") {
+			t.Fatalf("the input was read without a mapping: %s", logged.String())
+		}
+		for _, chr := range cp.OutputChromosomes() {
+			data, err := os.ReadFile(cp.OutputPath(chr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.IndexByte(data, '\r') >= 0 {
+				t.Errorf("mmap %v: %s has a carriage return", mmap, cp.OutputPath(chr))
+			}
+		}
+		if got := readOutputs(t, cp); !sameRecords(got, records) {
+			t.Errorf("mmap %v: got %d records, want the %d input records", mmap, len(got), len(records))
+		}
+	}
+}
+
+func TestSplitLongLine(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a 50MB line")
+	}
+	long := `{"chr":"chr2","evidence":"` + strings.Repeat("ACGT", 50*1024*1024/4+1) + `"}`
+	records := []string{`{"chr":"chr1","pos":1}`, long, `{"chr":"chr2","pos":2}`}
+	input := writeInput(t, "in.jsonl", records, "\n")
+	for _, opts := range []Options{{Workers: 1}, {Workers: 4}, {Workers: 1, Mmap: true}} {
+		cp := runSplit(t, []string{input}, opts)
+		got := readOutput(t, cp, "chr2")
+		if len(got) != 2 || got[0] != long || got[1] != records[2] {
+			t.Errorf("workers %d, mmap %v: the long line did not reach the chr2 output unchanged", opts.Workers, opts.Mmap)
+		}
+		if got := readOutput(t, cp, "chr1"); !slices.Equal(got, records[:1]) {
+			t.Errorf("workers %d, mmap %v: chr1 got %.80q, want %q", opts.Workers, opts.Mmap, got, records[:1])
+		}
+	}
+}
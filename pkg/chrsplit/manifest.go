@@ -0,0 +1,183 @@
+package chrsplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Manifest is the machine-readable summary of a run
+type Manifest struct {
+	Inputs         []InputStat       `json:"inputs"`
+	Prefix         string            `json:"prefix"`
+	Outputs        []ManifestOutput  `json:"outputs"`
+	Malformed      int               `json:"malformed_lines,omitempty"`
+	Oversize       int               `json:"oversize_records,omitempty"`
+	Comments       int               `json:"comment_lines,omitempty"`
+	Filtered       int               `json:"filtered_records,omitempty"`
+	DroppedUnknown int               `json:"dropped_unknown_records,omitempty"`
+	Deduplicated   int               `json:"deduplicated_records,omitempty"`
+	HeaderRecords  []json.RawMessage `json:"header_records,omitempty"`
+	ElapsedSeconds float64           `json:"elapsed_seconds"`
+}
+
+// ManifestOutput describes one output file
+type ManifestOutput struct {
+	Chromosome string `json:"chromosome"`
+	File       string `json:"file"`
+	// Lines counts the lines of the file; with Append, those of the earlier
+	// runs only when their manifest was merged in (see WriteManifest)
+	Lines int   `json:"lines"`
+	Bytes int64 `json:"bytes"`
+	// AppendedLines is the number of lines the Append run added
+	AppendedLines int `json:"appended_lines,omitempty"`
+	// UncompressedBytes is the size of a compressed output's content
+	UncompressedBytes int64 `json:"uncompressed_bytes,omitempty"`
+}
+
+// Manifest builds the manifest of the last ProcessFile call. It must be
+// called after ProcessFile returned, so the output sizes are final.
+func (cp *ChromosomeProcessor) Manifest(elapsed time.Duration) Manifest {
+	m := Manifest{
+		Inputs:         cp.InputStats(),
+		Prefix:         cp.prefix,
+		Malformed:      cp.tally.malformed,
+		Oversize:       cp.tally.oversize,
+		Comments:       cp.tally.comment,
+		Filtered:       cp.tally.filtered,
+		DroppedUnknown: cp.tally.dropped,
+		Deduplicated:   cp.tally.dedup,
+		HeaderRecords:  cp.HeaderRecords(),
+		ElapsedSeconds: elapsed.Seconds(),
+	}
+
+	chrs := cp.OutputChromosomes()
+	if cp.opts.WriteMalformed && cp.tally.malformed > 0 {
+		chrs = append(chrs, MalformedChr)
+	}
+	if cp.opts.OversizePolicy == OversizeRoute && cp.tally.oversize > 0 {
+		chrs = append(chrs, OversizeChr)
+	}
+	if cp.tally.fasta > 0 {
+		chrs = append(chrs, FastaChr)
+	}
+	for _, chr := range chrs {
+		for i, part := range cp.OutputParts(chr) {
+			out := ManifestOutput{
+				Chromosome: chr,
+				File:       part.Path,
+				Lines:      part.Lines,
+			}
+			if cp.parts[chr] <= 1 {
+				switch chr {
+				case MalformedChr:
+					out.Lines = cp.tally.malformed
+				case OversizeChr:
+					out.Lines = cp.tally.oversize
+				case FastaChr:
+					out.Lines = cp.tally.fasta
+				}
+			}
+			if cp.opts.Append {
+				out.AppendedLines = out.Lines
+			}
+			if info, err := os.Stat(out.File); err == nil {
+				out.Bytes = info.Size()
+			}
+			if cp.outputCompression() != CompressionNone {
+				out.UncompressedBytes = cp.routedBytes[partKey(chr, i+1)]
+			}
+			m.Outputs = append(m.Outputs, out)
+		}
+	}
+	return m
+}
+
+// ReadManifest reads a manifest written by WriteManifest
+func ReadManifest(path string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, fmt.Errorf("failed to read manifest %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("failed to parse manifest %s: %v", path, err)
+	}
+	return m, nil
+}
+
+// ChromosomeLines returns the lines of all the outputs of chr
+func (m Manifest) ChromosomeLines(chr string) int {
+	lines := 0
+	for _, out := range m.Outputs {
+		if out.Chromosome == chr {
+			lines += out.Lines
+		}
+	}
+	return lines
+}
+
+// addPrior folds in the manifest of the earlier runs that wrote the files
+// appended to: their inputs come first, and the line and record counts
+// become cumulative. An output of prior not written to again is kept.
+func (m *Manifest) addPrior(prior Manifest) {
+	m.Inputs = append(prior.Inputs, m.Inputs...)
+	m.Malformed += prior.Malformed
+	m.Oversize += prior.Oversize
+	m.Comments += prior.Comments
+	m.Filtered += prior.Filtered
+	m.DroppedUnknown += prior.DroppedUnknown
+	m.Deduplicated += prior.Deduplicated
+	m.ElapsedSeconds += prior.ElapsedSeconds
+	if len(m.HeaderRecords) == 0 {
+		m.HeaderRecords = prior.HeaderRecords
+	}
+
+	index := make(map[string]int, len(m.Outputs))
+	for i, out := range m.Outputs {
+		index[out.File] = i
+	}
+	for _, out := range prior.Outputs {
+		i, ok := index[out.File]
+		if !ok {
+			out.AppendedLines = 0
+			m.Outputs = append(m.Outputs, out)
+			continue
+		}
+		m.Outputs[i].Lines += out.Lines
+		m.Outputs[i].UncompressedBytes += out.UncompressedBytes
+	}
+}
+
+// WriteManifest writes the manifest of the last ProcessFile call as JSON to
+// path, or to stdout when path is "-". With Append, a manifest already at
+// path is taken as that of the runs that wrote the files appended to, and
+// updated with this run rather than replaced.
+func (cp *ChromosomeProcessor) WriteManifest(path string, elapsed time.Duration) error {
+	m := cp.Manifest(elapsed)
+	if cp.opts.Append && path != "-" {
+		if _, err := os.Stat(path); err == nil {
+			prior, err := ReadManifest(path)
+			if err != nil {
+				return err
+			}
+			m.addPrior(prior)
+		}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if path == "-" {
+		if _, err := os.Stdout.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write manifest to stdout: %v", err)
+		}
+		return nil
+	}
+	// a prior manifest is only replaced once the new one is complete
+	if err := writeFileSynced(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write manifest %s: %v", path, err)
+	}
+	return nil
+}
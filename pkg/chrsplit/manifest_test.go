@@ -0,0 +1,72 @@
+package chrsplit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAppendManifestCumulative(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+	days := [][]string{testRecords(400), testRecords(40), testRecords(4)}
+	var inputs []string
+	for i, records := range days {
+		input := writeInput(t, "day.jsonl", records, "\n")
+		inputs = append(inputs, input)
+		cp := runSplit(t, []string{input}, Options{OutputDir: dir, Append: i > 0})
+		if err := cp.WriteManifest(path, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := ReadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputNames []string
+	for _, input := range m.Inputs {
+		inputNames = append(inputNames, input.Input)
+	}
+	if !slices.Equal(inputNames, inputs) {
+		t.Errorf("inputs: got %v, want every run's in order %v", inputNames, inputs)
+	}
+	if m.ElapsedSeconds != 3 {
+		t.Errorf("elapsed: got %v s, want 3 s over the three runs", m.ElapsedSeconds)
+	}
+	if len(m.Outputs) != len(testChromosomes)+1 {
+		t.Fatalf("got %d outputs, want %d", len(m.Outputs), len(testChromosomes)+1)
+	}
+	// testRecords spreads the records evenly over chr1, chr2, chrX and
+	// chrUn, which is unknown
+	for _, out := range m.Outputs {
+		if out.Lines != 111 || out.AppendedLines != 1 {
+			t.Errorf("%s: got %d lines (%d appended), want 111 (1 appended)", out.Chromosome, out.Lines, out.AppendedLines)
+		}
+		data, err := os.ReadFile(out.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := bytes.Count(data, []byte("\n")); lines != out.Lines {
+			t.Errorf("%s: the manifest counts %d lines, the file has %d", out.Chromosome, out.Lines, lines)
+		}
+	}
+}
+
+func TestAddPriorKeepsOutputsOfEarlierRuns(t *testing.T) {
+	m := Manifest{Outputs: []ManifestOutput{{Chromosome: "chr1", File: "out_chr1.jsonl", Lines: 2, AppendedLines: 2}}}
+	m.addPrior(Manifest{Outputs: []ManifestOutput{
+		{Chromosome: "chr1", File: "out_chr1.jsonl", Lines: 5, AppendedLines: 5},
+		{Chromosome: "chr2", File: "out_chr2.jsonl", Lines: 3, AppendedLines: 3},
+	}})
+	want := []ManifestOutput{
+		{Chromosome: "chr1", File: "out_chr1.jsonl", Lines: 7, AppendedLines: 2},
+		{Chromosome: "chr2", File: "out_chr2.jsonl", Lines: 3},
+	}
+	if !slices.Equal(m.Outputs, want) {
+		t.Errorf("got %+v, want %+v", m.Outputs, want)
+	}
+}
@@ -0,0 +1,189 @@
+package chrsplit
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// mergeSource is one input of a merged run, holding its next record until
+// the merge routes it
+type mergeSource struct {
+	index   int
+	name    string
+	input   *inputReader
+	scanner recordScanner
+	stat    InputStat
+	lineNum int
+	// the next record: its output, chromosome value and position
+	line []byte
+	out  string
+	chr  string
+	pos  int64
+	// the previous record, which the next one must not sort before
+	started bool
+	prevChr string
+	prevPos int64
+}
+
+// mergeKeyLess orders records by chromosome, in natural order, then by
+// position
+func mergeKeyLess(chrA string, posA int64, chrB string, posB int64) bool {
+	if chrA != chrB {
+		return naturalChrLess(chrA, chrB)
+	}
+	return posA < posB
+}
+
+// mergeHeap is a min-heap of the sources by their next record; ties go to
+// the earlier input, so equal records keep the input order
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.chr == b.chr && a.pos == b.pos {
+		return a.index < b.index
+	}
+	return mergeKeyLess(a.chr, a.pos, b.chr, b.pos)
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	src := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return src
+}
+
+// checkMergeInputs rejects the runs the merge cannot read side by side:
+// archives, followed inputs and formats whose state follows a single input
+func (cp *ChromosomeProcessor) checkMergeInputs() error {
+	switch {
+	case cp.opts.Follow:
+		return fmt.Errorf("a followed input cannot be merged")
+	case cp.opts.Checkpoint != "":
+		return fmt.Errorf("a merged run cannot be checkpointed")
+	case cp.isGFF():
+		return fmt.Errorf("gff inputs cannot be merged")
+	case cp.isDelimited() && cp.opts.ChrColumn != "":
+		return fmt.Errorf("inputs with a header row (--chr-column) cannot be merged")
+	}
+	for _, path := range cp.inputFiles {
+		if isTarInput(path) || isZipInput(path) {
+			return fmt.Errorf("cannot merge %s: archive members cannot be merged", path)
+		}
+	}
+	return nil
+}
+
+// processMerged reads every input at once, each sorted by chromosome and
+// position, and routes their records in merged order, so every output is
+// sorted as well. Lines without a chromosome or position (and header,
+// comment and filtered lines) are routed as soon as they are read. A record
+// sorting before the previous one of its input is an error.
+func (cp *ChromosomeProcessor) processMerged() error {
+	sources := make([]*mergeSource, 0, len(cp.inputFiles))
+	defer func() {
+		for _, src := range sources {
+			src.stat.Bytes = src.input.raw.n
+			src.input.Close()
+			cp.inputStats = append(cp.inputStats, src.stat)
+		}
+	}()
+
+	h := make(mergeHeap, 0, len(cp.inputFiles))
+	for i, path := range cp.inputFiles {
+		name := InputDisplayName(path)
+		input, err := cp.openInput(path)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", name, err)
+		}
+		src := &mergeSource{index: i, name: name, input: input, scanner: cp.newScanner(input), stat: InputStat{Input: name}}
+		sources = append(sources, src)
+		cp.logf("Opened %s", name)
+
+		ok, err := cp.advanceMerge(src)
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, src)
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 && !cp.atLineLimit() {
+		if cp.interrupted() {
+			return ErrInterrupted
+		}
+		src := h[0]
+		if err := cp.writeLine(src.out, src.line); err != nil {
+			return fmt.Errorf("%v at %s %s", err, src.name, cp.recordPos(src.lineNum))
+		}
+		src.stat.Lines++
+		if cp.routedLine() {
+			break
+		}
+
+		ok, err := cp.advanceMerge(src)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// advanceMerge reads the next record of a source that takes part in the
+// merge, routing the lines that do not on the way. It reports false at the
+// end of the input.
+func (cp *ChromosomeProcessor) advanceMerge(src *mergeSource) (bool, error) {
+	for src.scanner.Scan() {
+		if cp.interrupted() {
+			return false, ErrInterrupted
+		}
+		src.lineNum++
+		line := src.scanner.Bytes()
+		if cp.skipLine() || len(line) == 0 {
+			continue
+		}
+
+		out, err := cp.checkLine(cp.routeLine(line), line, src.name, src.lineNum)
+		if err != nil {
+			return false, err
+		}
+		chr, hasChr := cp.ExtractChromosome(line)
+		pos, hasPos := cp.ExtractPosition(line)
+		switch out {
+		case commentChr, headerChr, filteredChr, MalformedChr, OversizeChr:
+			hasChr = false
+		}
+		if !hasChr || !hasPos {
+			if err := cp.writeLine(out, line); err != nil {
+				return false, fmt.Errorf("%v at %s %s", err, src.name, cp.recordPos(src.lineNum))
+			}
+			src.stat.Lines++
+			if cp.routedLine() {
+				return false, nil
+			}
+			continue
+		}
+
+		if src.started && mergeKeyLess(chr, pos, src.prevChr, src.prevPos) {
+			return false, fmt.Errorf("%s is not sorted at %s: %s:%d comes after %s:%d (inputs must be sorted by chromosome, in natural order, then position)",
+				src.name, cp.recordPos(src.lineNum), chr, pos, src.prevChr, src.prevPos)
+		}
+		src.started, src.prevChr, src.prevPos = true, chr, pos
+		src.line, src.out, src.chr, src.pos = line, out, chr, pos
+		return true, nil
+	}
+	if err := src.scanner.Err(); err != nil {
+		return false, fmt.Errorf("error reading %s at %s: %v", src.name, cp.recordPos(src.lineNum+1), err)
+	}
+	cp.logf("Finished %s: %d lines", src.name, src.stat.Lines)
+	return false, nil
+}
@@ -0,0 +1,40 @@
+package chrsplit
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMergeSorted(t *testing.T) {
+	a := writeInput(t, "a.jsonl", []string{`{"chr":"chr1","pos":1}`, `{"chr":"chr1","pos":5}`, `{"chr":"chr2","pos":3}`}, "\n")
+	b := writeInput(t, "b.jsonl", []string{`{"chr":"chr1","pos":2}`, `{"chr":"chr1","pos":5,"id":"b"}`, `{"chr":"chrX","pos":1}`}, "\n")
+	cp := runSplit(t, []string{a, b}, Options{MergeSorted: true, PosFieldName: "pos"})
+
+	// equal positions keep the input order
+	want := []string{`{"chr":"chr1","pos":1}`, `{"chr":"chr1","pos":2}`, `{"chr":"chr1","pos":5}`, `{"chr":"chr1","pos":5,"id":"b"}`}
+	if got := readOutput(t, cp, "chr1"); !slices.Equal(got, want) {
+		t.Errorf("chr1: got %q, want %q", got, want)
+	}
+}
+
+func TestMergeSortedUnsortedInput(t *testing.T) {
+	a := writeInput(t, "a.jsonl", []string{`{"chr":"chr1","pos":1}`, `{"chr":"chr2","pos":1}`}, "\n")
+	b := writeInput(t, "b.jsonl", []string{`{"chr":"chr1","pos":7}`, "", `{"chr":"chr1","pos":3}`}, "\n")
+	dir := t.TempDir()
+	cp := NewChromosomeProcessor([]string{a, b}, "out", "chr", testChromosomes, Options{OutputDir: dir, MergeSorted: true, PosFieldName: "pos"})
+
+	err := cp.ProcessFile()
+	if err == nil {
+		t.Fatal("merging an unsorted input succeeded")
+	}
+	for _, want := range []string{b + " is not sorted at line 3", "chr1:3 comes after chr1:7"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got %q, want it to contain %q", err, want)
+		}
+	}
+	if outputs, _ := filepath.Glob(filepath.Join(dir, "*")); len(outputs) != 0 {
+		t.Errorf("the failed merge left %v", outputs)
+	}
+}
@@ -0,0 +1,142 @@
+package chrsplit
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// mmapReader splits a memory-mapped input into lines like lineReader, but
+// the lines are slices of the mapping: nothing is copied, and a line stays
+// valid until the input is closed
+type mmapReader struct {
+	data []byte
+	next int
+	line []byte
+	// bom is the length of a UTF-8 byte order mark skipped at the start,
+	// which Offset leaves out like decodeText
+	bom int
+	raw *countingReader
+}
+
+// Scan advances to the next line, which is then available through Bytes
+func (mr *mmapReader) Scan() bool {
+	if mr.next >= len(mr.data) {
+		return false
+	}
+	start := mr.next
+	end := len(mr.data)
+	if i := bytes.IndexByte(mr.data[start:], '\n'); i >= 0 {
+		end = start + i + 1
+	}
+	mr.next = end
+	n := int64(end - start)
+	mr.raw.n += n
+	if mr.raw.total != nil {
+		mr.raw.total.Add(n)
+	}
+
+	line := mr.data[start:end]
+	if line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	mr.line = line
+	return true
+}
+
+// Bytes returns the current line, a slice of the mapping
+func (mr *mmapReader) Bytes() []byte {
+	return mr.line
+}
+
+// Err returns nil: a mapping has no read errors
+func (mr *mmapReader) Err() error {
+	return nil
+}
+
+// Offset returns the number of bytes read up to the end of the current line
+func (mr *mmapReader) Offset() int64 {
+	return int64(mr.next - mr.bom)
+}
+
+// mmapFormat reports whether the input format is read line by line as is,
+// which a mapping can stand in for
+func (cp *ChromosomeProcessor) mmapFormat() bool {
+	if cp.opts.RecordDelimiter != "" || cp.opts.RangeEnd > 0 {
+		return false
+	}
+	switch cp.opts.Format {
+	case "", FormatJSONL, FormatVCF, FormatBED, FormatGFF, FormatSAM, FormatTSV:
+		return cp.opts.InputEncoding == "" || cp.opts.InputEncoding == EncodingAuto || cp.opts.InputEncoding == EncodingUTF8
+	}
+	return false
+}
+
+// openMapped maps an input with the Mmap option. It returns nil, and the
+// input is read as usual, for anything but a non-empty, uncompressed UTF-8
+// regular file: stdin, pipes, remote and compressed inputs fall back.
+func (cp *ChromosomeProcessor) openMapped(path string) *inputReader {
+	if path == StdinInput || IsRemoteInput(path) || !cp.mmapFormat() {
+		return nil
+	}
+	if ck := cp.ckpt.resumeAt; ck != nil && ck.Input == cp.ckpt.input {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		file.Close()
+		return nil
+	}
+	data, unmap, err := mapFile(file, info.Size())
+	if err != nil {
+		cp.logf("Not mapping %s: %v", InputDisplayName(path), err)
+		file.Close()
+		return nil
+	}
+
+	magic := data[:min(len(data), magicLen)]
+	fallback := ""
+	switch {
+	case unsupportedCompression(path, magic) != "" && (cp.opts.InputCompression == CompressionAuto || cp.opts.InputCompression == ""):
+		fallback = "compressed"
+	case detectCompression(cp.opts.InputCompression, path, magic) != CompressionNone:
+		fallback = "compressed"
+	case bytes.HasPrefix(magic, utf16LEBOM), bytes.HasPrefix(magic, utf16BEBOM):
+		fallback = "UTF-16"
+	case magic[0] == recordSeparator:
+		fallback = "a JSON text sequence"
+	}
+	if fallback != "" {
+		cp.logf("Not mapping %s: %s input", InputDisplayName(path), fallback)
+		unmap()
+		file.Close()
+		return nil
+	}
+
+	mr := &mmapReader{data: data, raw: &countingReader{total: &cp.progressBytes}}
+	if bytes.HasPrefix(data, utf8BOM) {
+		mr.bom = len(utf8BOM)
+		mr.next = mr.bom
+	}
+	cp.logf("Mapped %s (%d bytes)", InputDisplayName(path), len(data))
+	return &inputReader{
+		Reader:  bytes.NewReader(data),
+		raw:     mr.raw,
+		mapped:  mr,
+		closers: []io.Closer{file, closerFunc(unmap)},
+	}
+}
+
+// closerFunc adapts a function to io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
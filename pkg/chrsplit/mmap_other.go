@@ -0,0 +1,13 @@
+//go:build !unix
+
+package chrsplit
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile is not available on this platform; inputs are read as usual
+func mapFile(file *os.File, size int64) ([]byte, func() error, error) {
+	return nil, nil, errors.New("memory mapping is not supported on this platform")
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package chrsplit
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps size bytes of a file read-only. Nothing is read up front:
+// the OS faults pages in as they are touched and can evict them again, so
+// files larger than memory map fine.
+func mapFile(file *os.File, size int64) ([]byte, func() error, error) {
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,362 @@
+package chrsplit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FormatMsgpack reads consecutive MessagePack maps. Only the key routed on
+// is decoded, and the records are written with their original encoding to
+// .msgpack outputs, without a newline between them.
+const FormatMsgpack = "msgpack"
+
+// msgpackChunk is the most a record grows by per read, so a corrupt length
+// fails at the end of the input rather than on a huge allocation
+const msgpackChunk = 64 * 1024
+
+// isMsgpack reports whether the inputs are MessagePack
+func (cp *ChromosomeProcessor) isMsgpack() bool {
+	return cp.opts.Format == FormatMsgpack
+}
+
+// msgpackHead describes a MessagePack value from its first byte: the size of
+// its length field, and what follows the length field (fixed payload bytes,
+// plus the length in bytes, or in nested values per unit of length)
+type msgpackHead struct {
+	lenBytes int
+	fixed    int
+	values   int
+	perLen   int
+}
+
+// parseMsgpackHead decodes the first byte of a MessagePack value; it
+// reports false for the never used 0xc1
+func parseMsgpackHead(b byte) (msgpackHead, bool) {
+	switch {
+	case b <= 0x7f, b >= 0xe0, b == 0xc0, b == 0xc2, b == 0xc3:
+		return msgpackHead{}, true
+	case b <= 0x8f:
+		return msgpackHead{values: 2 * int(b&0x0f)}, true
+	case b <= 0x9f:
+		return msgpackHead{values: int(b & 0x0f)}, true
+	case b <= 0xbf:
+		return msgpackHead{fixed: int(b & 0x1f)}, true
+	}
+
+	switch b {
+	case 0xc4, 0xd9: // bin 8, str 8
+		return msgpackHead{lenBytes: 1}, true
+	case 0xc5, 0xda: // bin 16, str 16
+		return msgpackHead{lenBytes: 2}, true
+	case 0xc6, 0xdb: // bin 32, str 32
+		return msgpackHead{lenBytes: 4}, true
+	case 0xc7: // ext 8
+		return msgpackHead{lenBytes: 1, fixed: 1}, true
+	case 0xc8: // ext 16
+		return msgpackHead{lenBytes: 2, fixed: 1}, true
+	case 0xc9: // ext 32
+		return msgpackHead{lenBytes: 4, fixed: 1}, true
+	case 0xca, 0xce, 0xd2: // float 32, uint 32, int 32
+		return msgpackHead{fixed: 4}, true
+	case 0xcb, 0xcf, 0xd3: // float 64, uint 64, int 64
+		return msgpackHead{fixed: 8}, true
+	case 0xcc, 0xd0: // uint 8, int 8
+		return msgpackHead{fixed: 1}, true
+	case 0xcd, 0xd1: // uint 16, int 16
+		return msgpackHead{fixed: 2}, true
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1 to 16, plus the type
+		return msgpackHead{fixed: 1<<(b-0xd4) + 1}, true
+	case 0xdc: // array 16
+		return msgpackHead{lenBytes: 2, perLen: 1}, true
+	case 0xdd: // array 32
+		return msgpackHead{lenBytes: 4, perLen: 1}, true
+	case 0xde: // map 16
+		return msgpackHead{lenBytes: 2, perLen: 2}, true
+	case 0xdf: // map 32
+		return msgpackHead{lenBytes: 4, perLen: 2}, true
+	}
+	return msgpackHead{}, false
+}
+
+// msgpackLen decodes a big-endian length field
+func msgpackLen(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+// msgpackSize returns the size of the value at the start of b, or -1 when
+// it is corrupt or cut short. Nested values are counted, not recursed into.
+func msgpackSize(b []byte) int {
+	pos, pending := 0, 1
+	for pending > 0 {
+		if pos >= len(b) {
+			return -1
+		}
+		head, ok := parseMsgpackHead(b[pos])
+		if !ok || pos+1+head.lenBytes > len(b) {
+			return -1
+		}
+		n := msgpackLen(b[pos+1 : pos+1+head.lenBytes])
+		pos += 1 + head.lenBytes + head.fixed
+		pending += head.values - 1
+		if head.perLen > 0 {
+			pending += n * head.perLen
+		} else {
+			pos += n
+		}
+	}
+	if pos > len(b) {
+		return -1
+	}
+	return pos
+}
+
+// msgpackString returns the content of a str value at the start of b
+func msgpackString(b []byte) ([]byte, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+	var start int
+	switch {
+	case b[0] >= 0xa0 && b[0] <= 0xbf:
+		start = 1
+	case b[0] == 0xd9:
+		start = 2
+	case b[0] == 0xda:
+		start = 3
+	case b[0] == 0xdb:
+		start = 5
+	default:
+		return nil, false
+	}
+	size := msgpackSize(b)
+	if size < 0 {
+		return nil, false
+	}
+	return b[start:size], true
+}
+
+// msgpackNumber returns the value of an integer or float at the start of b
+func msgpackNumber(b []byte) (float64, bool) {
+	if len(b) == 0 || msgpackSize(b) < 0 {
+		return 0, false
+	}
+	switch c := b[0]; {
+	case c <= 0x7f:
+		return float64(c), true
+	case c >= 0xe0:
+		return float64(int8(c)), true
+	case c == 0xca:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b[1:]))), true
+	case c == 0xcb:
+		return math.Float64frombits(binary.BigEndian.Uint64(b[1:])), true
+	case c == 0xcc:
+		return float64(b[1]), true
+	case c == 0xcd:
+		return float64(binary.BigEndian.Uint16(b[1:])), true
+	case c == 0xce:
+		return float64(binary.BigEndian.Uint32(b[1:])), true
+	case c == 0xcf:
+		return float64(binary.BigEndian.Uint64(b[1:])), true
+	case c == 0xd0:
+		return float64(int8(b[1])), true
+	case c == 0xd1:
+		return float64(int16(binary.BigEndian.Uint16(b[1:]))), true
+	case c == 0xd2:
+		return float64(int32(binary.BigEndian.Uint32(b[1:]))), true
+	case c == 0xd3:
+		return float64(int64(binary.BigEndian.Uint64(b[1:]))), true
+	}
+	return 0, false
+}
+
+// msgpackMap returns the number of entries of a map at the start of b and
+// the bytes following its header
+func msgpackMap(b []byte) (int, []byte, bool) {
+	switch {
+	case len(b) >= 1 && b[0] >= 0x80 && b[0] <= 0x8f:
+		return int(b[0] & 0x0f), b[1:], true
+	case len(b) >= 3 && b[0] == 0xde:
+		return msgpackLen(b[1:3]), b[3:], true
+	case len(b) >= 5 && b[0] == 0xdf:
+		return msgpackLen(b[1:5]), b[5:], true
+	}
+	return 0, nil, false
+}
+
+// msgpackLookup returns the value at path (a key per level of nested maps)
+// in the map at the start of record, skipping every other entry undecoded
+func msgpackLookup(record []byte, path []string) ([]byte, bool) {
+	value := record
+	for _, key := range path {
+		n, entries, ok := msgpackMap(value)
+		if !ok {
+			return nil, false
+		}
+		found := false
+		for i := 0; i < n; i++ {
+			size := msgpackSize(entries)
+			if size < 0 {
+				return nil, false
+			}
+			name, isString := msgpackString(entries)
+			entries = entries[size:]
+			if isString && string(name) == key {
+				value, found = entries, true
+				break
+			}
+			if size = msgpackSize(entries); size < 0 {
+				return nil, false
+			}
+			entries = entries[size:]
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// msgpackField returns the string or number at path in a record, as text
+func msgpackField(record []byte, path []string) (string, bool) {
+	value, ok := msgpackLookup(record, path)
+	if !ok {
+		return "", false
+	}
+	if s, ok := msgpackString(value); ok {
+		return string(s), true
+	}
+	if n, ok := msgpackNumber(value); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// isMsgpackMap reports whether a record is a MessagePack map, which is
+// checked like JSON validity with the Strict and Validate options
+func isMsgpackMap(record []byte) bool {
+	_, _, ok := msgpackMap(record)
+	return ok
+}
+
+// splitFieldPath splits a gjson-style path on its unescaped dots, e.g.
+// "info.chrom" into [info chrom] and `a\.b` into [a.b]
+func splitFieldPath(path string) []string {
+	var keys []string
+	var key strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path):
+			i++
+			key.WriteByte(path[i])
+		case path[i] == '.':
+			keys = append(keys, key.String())
+			key.Reset()
+		default:
+			key.WriteByte(path[i])
+		}
+	}
+	return append(keys, key.String())
+}
+
+// msgpackReader splits a stream of MessagePack values into records. The
+// stream cannot be resynchronised after a corrupt value, so that is a read
+// error naming its byte offset.
+type msgpackReader struct {
+	br     *bufio.Reader
+	record []byte
+	offset int64
+	err    error
+}
+
+func newMsgpackReader(r io.Reader) *msgpackReader {
+	return &msgpackReader{br: bufio.NewReaderSize(r, lineBufferSize)}
+}
+
+// Scan advances to the next record, which is then available through Bytes
+func (mr *msgpackReader) Scan() bool {
+	if cap(mr.record) > maxRetainedLine {
+		mr.record = nil
+	}
+	mr.record = mr.record[:0]
+	start := mr.offset
+
+	for pending := 1; pending > 0; pending-- {
+		c, err := mr.br.ReadByte()
+		if err == io.EOF && len(mr.record) == 0 {
+			return false
+		}
+		if err != nil {
+			return mr.fail(start, err)
+		}
+		head, ok := parseMsgpackHead(c)
+		if !ok {
+			return mr.fail(start, fmt.Errorf("invalid type byte 0x%02x at byte %d of the record", c, len(mr.record)))
+		}
+		mr.record = append(mr.record, c)
+		if err := mr.read(head.lenBytes); err != nil {
+			return mr.fail(start, err)
+		}
+		n := msgpackLen(mr.record[len(mr.record)-head.lenBytes:])
+		pending += head.values
+		payload := head.fixed
+		if head.perLen > 0 {
+			pending += n * head.perLen
+		} else {
+			payload += n
+		}
+		if err := mr.read(payload); err != nil {
+			return mr.fail(start, err)
+		}
+	}
+	mr.offset += int64(len(mr.record))
+	return true
+}
+
+// read appends the next n bytes of the stream to the record
+func (mr *msgpackReader) read(n int) error {
+	for n > 0 {
+		chunk := min(n, msgpackChunk)
+		end := len(mr.record)
+		mr.record = append(mr.record, make([]byte, chunk)...)
+		if _, err := io.ReadFull(mr.br, mr.record[end:]); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// fail ends scanning with an error for the record starting at start
+func (mr *msgpackReader) fail(start int64, err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("truncated record at byte offset %d", start)
+	} else {
+		err = fmt.Errorf("corrupt MessagePack record at byte offset %d: %v", start, err)
+	}
+	mr.err = err
+	return false
+}
+
+// Bytes returns the current record. It is only valid until the next Scan.
+func (mr *msgpackReader) Bytes() []byte {
+	return mr.record
+}
+
+// Err returns the error that ended scanning, if any
+func (mr *msgpackReader) Err() error {
+	return mr.err
+}
+
+// Offset returns the number of bytes read up to the end of the current record
+func (mr *msgpackReader) Offset() int64 {
+	return mr.offset
+}
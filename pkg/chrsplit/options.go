@@ -0,0 +1,215 @@
+package chrsplit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Check reports the first option that is out of range or that cannot be
+// combined with another one; the zero Options passes. The errors name the
+// command-line flags of the options.
+func (o Options) Check() error {
+	format, outputFormat := o.Format, o.OutputFormat
+	if format == "" {
+		format = FormatJSONL
+	}
+	if outputFormat == "" {
+		outputFormat = FormatJSONL
+	}
+	compression := o.Compress
+	if compression == "" {
+		compression = CompressionNone
+		if o.Gzip {
+			compression = CompressionGzip
+		}
+	}
+	delimited := format == FormatTSV || format == FormatCSV
+	text := delimited || format == FormatVCF || format == FormatBED || format == FormatGFF || format == FormatSAM
+	binary := format == FormatMsgpack
+
+	if o.InputCompression != "" {
+		if err := ValidateInputCompression(o.InputCompression); err != nil {
+			return err
+		}
+	}
+	if err := ValidateOutputCompression(compression, o.CompressLevel); err != nil {
+		return err
+	}
+	if o.CompressThreads < 0 {
+		return fmt.Errorf("--compress-threads must not be negative")
+	}
+	if o.CompressThreads > 1 && compression == CompressionNone {
+		return fmt.Errorf("--compress-threads needs output compression")
+	}
+	if o.FilenameTemplate != "" {
+		if err := ValidateFilenameTemplate(o.FilenameTemplate); err != nil {
+			return err
+		}
+		if o.Shard > 0 && !strings.Contains(o.FilenameTemplate, "{shard}") {
+			return fmt.Errorf("--shard needs a {shard} placeholder in --filename-template")
+		}
+	}
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	if err := ValidateOutputFormat(outputFormat); err != nil {
+		return err
+	}
+	if o.InputEncoding != "" {
+		if err := ValidateInputEncoding(o.InputEncoding); err != nil {
+			return err
+		}
+	}
+	if (outputFormat == FormatTSV) != (len(o.Fields) > 0) {
+		return fmt.Errorf("--output-format tsv requires --fields, and --fields requires --output-format tsv")
+	}
+	if (text || binary) && (outputFormat != FormatJSONL || len(o.KeepFields) > 0) {
+		return fmt.Errorf("--format %s cannot be combined with --output-format or --keep-fields", format)
+	}
+	if len(o.KeepFields) > 0 && outputFormat == FormatTSV {
+		return fmt.Errorf("--keep-fields cannot be combined with --output-format tsv (use --fields)")
+	}
+	if o.WriteMalformed && !o.Strict {
+		return fmt.Errorf("--malformed-output requires --strict")
+	}
+	if o.SortBuffer > 0 && o.Checkpoint != "" {
+		return fmt.Errorf("--sort-by-position cannot be combined with --checkpoint")
+	}
+	if o.JSONHeaderLines < 0 {
+		return fmt.Errorf("--json-header-lines must not be negative")
+	}
+	if o.FilterOp != "" {
+		if err := ValidateFilterOp(o.FilterOp); err != nil {
+			return err
+		}
+	}
+	if o.FilterField != "" && (text || binary) {
+		return fmt.Errorf("--filter-field needs JSON input")
+	}
+	if o.DedupField != "" && (text || binary) {
+		return fmt.Errorf("--dedup-field needs JSON input")
+	}
+	if o.StatsField != "" {
+		if text || binary {
+			return fmt.Errorf("--stats-field needs JSON input")
+		}
+		if o.Checkpoint != "" {
+			return fmt.Errorf("--stats-field cannot be combined with --checkpoint")
+		}
+	}
+	if (o.Dedup || o.DedupField != "") && o.Checkpoint != "" {
+		return fmt.Errorf("--dedup cannot be combined with --checkpoint")
+	}
+	if delimited && (o.ChrColumn == "") == (o.ChrColumnIndex == 0) {
+		return fmt.Errorf("--format %s needs one of --chr-column or --chr-column-index", format)
+	}
+	if !delimited && (o.ChrColumn != "" || o.ChrColumnIndex != 0) {
+		return fmt.Errorf("--chr-column and --chr-column-index need --format tsv or csv")
+	}
+	if o.ChrColumnIndex < 0 {
+		return fmt.Errorf("--chr-column-index counts from 1")
+	}
+	if o.Delimiter != 0 && !delimited {
+		return fmt.Errorf("--delimiter needs --format tsv or csv")
+	}
+	if o.RecordDelimiter != "" {
+		switch {
+		case len(o.RecordDelimiter) != 1:
+			return fmt.Errorf("invalid --record-delimiter %q (expected one byte)", o.RecordDelimiter)
+		case format != FormatJSONL || outputFormat != FormatJSONL:
+			return fmt.Errorf("--record-delimiter needs --format jsonl and --output-format jsonl")
+		case o.Follow:
+			return fmt.Errorf("--record-delimiter cannot be combined with --follow")
+		}
+	}
+	if o.UnknownName != "" && (o.UnknownName == "." || o.UnknownName == ".." || strings.ContainsAny(o.UnknownName, `/\`)) {
+		return fmt.Errorf("invalid --unknown-name %q", o.UnknownName)
+	}
+	if o.SkipLines < 0 || o.MaxLines < 0 {
+		return fmt.Errorf("--skip-lines and --max-lines must not be negative")
+	}
+	if o.Shard < 0 {
+		return fmt.Errorf("--shard must not be negative")
+	}
+	if o.RangeEnd > 0 {
+		switch {
+		case o.Shard == 0:
+			return fmt.Errorf("--byte-range needs --shard, so the outputs of the instances do not collide")
+		case format != FormatJSONL:
+			return fmt.Errorf("--byte-range needs --format jsonl")
+		case o.SkipLines > 0 || o.JSONHeaderLines > 0:
+			return fmt.Errorf("--byte-range cannot be combined with --skip-lines or --json-header-lines, which count from the start of the input")
+		case o.Follow || o.Checkpoint != "":
+			return fmt.Errorf("--byte-range cannot be combined with --follow or --checkpoint")
+		}
+	}
+	if o.Resume && o.Checkpoint == "" {
+		return fmt.Errorf("--resume needs --checkpoint")
+	}
+	if o.CheckpointLines < 0 || o.CheckpointInterval < 0 {
+		return fmt.Errorf("--checkpoint-lines and --checkpoint-interval must not be negative")
+	}
+	if o.NoUnknown && o.DropUnknown {
+		return fmt.Errorf("--no-unknown and --drop-unknown cannot be used together")
+	}
+	if binary && (len(o.CommentPrefixes) > 0 || o.HeaderPrefix != "" || o.JSONHeaderLines > 0 || o.HeaderTypeField != "") {
+		return fmt.Errorf("--format %s has no comment or header lines", format)
+	}
+	if o.SampleRate < 0 || o.SampleRate > 1 {
+		return fmt.Errorf("--sample-rate must be between 0 and 1")
+	}
+	if o.PerChrSample < 0 {
+		return fmt.Errorf("--per-chr-sample must not be negative")
+	}
+	if o.PerChrSample > 0 {
+		switch {
+		case o.SampleRate > 0:
+			return fmt.Errorf("--per-chr-sample and --sample-rate cannot be used together")
+		case o.Checkpoint != "":
+			return fmt.Errorf("--per-chr-sample cannot be combined with --checkpoint")
+		case o.Follow:
+			return fmt.Errorf("--per-chr-sample writes at the end of the input, which --follow never reaches")
+		}
+	}
+	if o.SampleRate > 0 && o.Checkpoint != "" {
+		return fmt.Errorf("--sample-rate cannot be combined with --checkpoint")
+	}
+	if o.SampleUnknown < 0 {
+		return fmt.Errorf("--sample-unknown must not be negative")
+	}
+	if o.BinSize < 0 {
+		return fmt.Errorf("--bin-size must not be negative")
+	}
+	if o.MergeSorted && o.Workers > 1 {
+		return fmt.Errorf("--merge-sorted routes on one goroutine, it cannot be combined with --workers")
+	}
+	if o.IndexEvery < 0 {
+		return fmt.Errorf("--index-every must not be negative")
+	}
+	if o.IndexEvery > 0 {
+		switch {
+		case compression != CompressionNone:
+			return fmt.Errorf("--index needs uncompressed outputs, the byte offsets of a %s file cannot be seeked to", compression)
+		case o.Append || o.Checkpoint != "" || o.DryRun:
+			return fmt.Errorf("--index cannot be combined with --append, --checkpoint or --dry-run")
+		}
+	}
+	if o.MaxLinesPerFile < 0 {
+		return fmt.Errorf("--max-lines-per-file must not be negative")
+	}
+	if o.KeepCommentHeader && len(o.CommentPrefixes) == 0 {
+		return fmt.Errorf("--keep-comments requires --comment-prefix")
+	}
+	if o.OversizePolicy != "" {
+		if err := ValidateOversizePolicy(o.OversizePolicy); err != nil {
+			return err
+		}
+	}
+	if o.MaxInvalidFraction < 0 || o.MaxInvalidFraction > 1 {
+		return fmt.Errorf("--max-invalid-fraction must be between 0 and 1")
+	}
+	if o.MaxOpenFiles < 0 {
+		return fmt.Errorf("--max-open-files must not be negative")
+	}
+	return nil
+}
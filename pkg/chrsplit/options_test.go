@@ -0,0 +1,52 @@
+package chrsplit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOptionsCheck(t *testing.T) {
+	for _, opts := range []Options{
+		{},
+		{Format: FormatTSV, ChrColumn: "chrom", Delimiter: ','},
+		{OutputFormat: FormatTSV, Fields: []string{"chr", "pos"}},
+		{Gzip: true, CompressThreads: 4},
+		{Checkpoint: "run.ckpt", Resume: true},
+		{RangeStart: 100, RangeEnd: 200, Shard: 2},
+		{IndexEvery: 1000, PosFieldName: "pos"},
+	} {
+		if err := opts.Check(); err != nil {
+			t.Errorf("%+v: %v", opts, err)
+		}
+	}
+
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{Format: "xml"}, "unsupported input format"},
+		{Options{InputCompression: "lz4"}, "unsupported input compression"},
+		{Options{CompressThreads: 2}, "--compress-threads needs output compression"},
+		{Options{Gzip: true, IndexEvery: 10}, "--index needs uncompressed outputs"},
+		{Options{Resume: true}, "--resume needs --checkpoint"},
+		{Options{OutputFormat: FormatTSV}, "--output-format tsv requires --fields"},
+		{Options{Format: FormatCSV}, "needs one of --chr-column or --chr-column-index"},
+		{Options{Delimiter: ';'}, "--delimiter needs --format tsv or csv"},
+		{Options{RecordDelimiter: "\x00", Follow: true}, "--record-delimiter cannot be combined with --follow"},
+		{Options{UnknownName: "../unknown"}, "invalid --unknown-name"},
+		{Options{RangeEnd: 100}, "--byte-range needs --shard"},
+		{Options{PerChrSample: 10, SampleRate: 0.5}, "cannot be used together"},
+		{Options{DedupField: "id", Checkpoint: "run.ckpt"}, "--dedup cannot be combined with --checkpoint"},
+		{Options{MergeSorted: true, Workers: 4}, "--merge-sorted"},
+		{Options{MaxInvalidFraction: 1.5}, "--max-invalid-fraction"},
+		{Options{OversizePolicy: "truncate"}, "unsupported oversize policy"},
+		{Options{KeepCommentHeader: true}, "--keep-comments requires --comment-prefix"},
+		{Options{FilenameTemplate: "{chr}.jsonl", Shard: 1}, "{shard} placeholder"},
+	}
+	for _, tt := range tests {
+		err := tt.opts.Check()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: got %v, want an error containing %q", tt.opts, err, tt.want)
+		}
+	}
+}
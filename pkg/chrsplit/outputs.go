@@ -0,0 +1,252 @@
+package chrsplit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// DefaultMaxOpenFiles is the default cap on output files held open at once,
+// well below the usual 1024 file descriptor limit
+const DefaultMaxOpenFiles = 256
+
+const (
+	// outputBufferSize is the write buffer of each output file
+	outputBufferSize = 4 * 1024 * 1024
+	// smallBufferSize is the write buffer used when there may be more
+	// outputs than open files, so thousands of buffers stay affordable
+	smallBufferSize = 256 * 1024
+)
+
+// maxOpenFiles returns the MaxOpenFiles option or its default
+func (cp *ChromosomeProcessor) maxOpenFiles() int {
+	if cp.opts.MaxOpenFiles > 0 {
+		return cp.opts.MaxOpenFiles
+	}
+	return DefaultMaxOpenFiles
+}
+
+// dynamicOutputName turns a field value into the name of its output. Path
+// separators and other characters unsafe in file names become '_', so a value
+// can never escape the output directory; an empty value goes to the unknown
+// output.
+func (cp *ChromosomeProcessor) dynamicOutputName(value string) string {
+	if value == "" {
+		return cp.unknownChr
+	}
+	return safeName(value)
+}
+
+// safeName replaces the characters of a value that are unsafe in a file or
+// directory name, path separators included, with '_'
+func safeName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, value)
+}
+
+// openOutput opens the (temporary) output file of chr, evicting the least recently used
+// output first when the MaxOpenFiles cap is reached. The file is created (and
+// truncated) the first time and appended to when reopened after an eviction;
+// a reopened gzip output starts a new gzip member, which readers handle as
+// one concatenated stream. In append mode the file is always appended to,
+// and the size of an existing file before the run is remembered for
+// discardOutputs.
+func (cp *ChromosomeProcessor) openOutput(chr string) (*bufio.Writer, error) {
+	if len(cp.out.files) >= cp.maxOpenFiles() {
+		evicted := cp.out.lru.Back().Value.(string)
+		cp.logf("Evicting %s (%d files open)", cp.TempPath(evicted), len(cp.out.files))
+		if err := cp.closeOutput(evicted); err != nil {
+			return nil, err
+		}
+	}
+
+	filename := cp.TempPath(chr)
+	if cp.opts.FilenameTemplate != "" && !cp.out.created[chr] {
+		if err := cp.claimOutputPath(chr); err != nil {
+			return nil, err
+		}
+		// a template may put outputs in directories of their own
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory %s: %v", filepath.Dir(filename), err)
+		}
+	}
+	if !cp.out.created[chr] && !cp.opts.Force && !cp.opts.Append {
+		// outputs created as their first record arrives were only checked
+		// by pattern up front, and a file may have appeared since
+		if _, err := os.Stat(cp.OutputPath(chr)); err == nil {
+			return nil, fmt.Errorf("output file %s already exists (use --force to overwrite)", cp.OutputPath(chr))
+		}
+	}
+	existed := false
+	if cp.opts.Append && !cp.out.created[chr] {
+		_, err := os.Stat(filename)
+		existed = err == nil
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if cp.out.created[chr] || cp.opts.Append {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(filename, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file %s: %v", filename, err)
+	}
+	if existed {
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to stat output file %s: %v", filename, err)
+		}
+		cp.out.appendBase[chr] = info.Size()
+	}
+	// compression sits between the buffer and the file: bufio -> gzip or
+	// zstd -> file
+	zw, err := cp.newCompressWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to start %s output %s: %v", cp.outputCompression(), filename, err)
+	}
+	if cp.out.created[chr] {
+		cp.logf("Reopened %s", filename)
+	} else {
+		cp.logf("Opened %s", filename)
+	}
+	cp.out.created[chr] = true
+	cp.out.files[chr] = file
+
+	size := outputBufferSize
+	if cp.lazyOutputs() || len(cp.chrNames)+1 > cp.maxOpenFiles() {
+		size = smallBufferSize
+	}
+
+	var writer *bufio.Writer
+	if zw != nil {
+		cp.out.compressors[chr] = zw
+		writer = bufio.NewWriterSize(zw, size)
+	} else {
+		writer = bufio.NewWriterSize(file, size)
+	}
+	cp.out.writers[chr] = writer
+	cp.out.lruElems[chr] = cp.out.lru.PushFront(chr)
+
+	// an output appended to already has the header from its first run
+	if cp.opts.Append && cp.out.appendBase[chr] > 0 {
+		cp.hdr.written[chr] = true
+	}
+	if cp.hdr.done && cp.hasHeader() && !cp.hdr.written[chr] {
+		if err := cp.writeHeader(chr, writer); err != nil {
+			return nil, err
+		}
+	}
+	return writer, nil
+}
+
+// checkExistingOutputs fails when an output file of this run already exists,
+// listing every conflict, so a reused prefix does not clobber an earlier
+// run. In dynamic mode and with BinSize the output names are not known up
+// front, so any file matching the output pattern counts.
+func (cp *ChromosomeProcessor) checkExistingOutputs() error {
+	var existing []string
+	if cp.lazyOutputs() {
+		matches, _ := filepath.Glob(cp.OutputPath("*"))
+		existing = matches
+	} else {
+		chrs := cp.OutputChromosomes()
+		if cp.opts.WriteMalformed {
+			chrs = append(chrs, MalformedChr)
+		}
+		if cp.opts.OversizePolicy == OversizeRoute {
+			chrs = append(chrs, OversizeChr)
+		}
+		if cp.isGFF() {
+			chrs = append(chrs, FastaChr)
+		}
+		for _, chr := range chrs {
+			if _, err := os.Stat(cp.OutputPath(chr)); err == nil {
+				existing = append(existing, cp.OutputPath(chr))
+			}
+			if cp.splitsParts() {
+				parts, _ := filepath.Glob(cp.OutputPath(chr + ".part*"))
+				existing = append(existing, parts...)
+			}
+		}
+	}
+
+	if len(existing) > 0 {
+		return fmt.Errorf("%d output file(s) already exist (use --force to overwrite):\n  %s", len(existing), strings.Join(existing, "\n  "))
+	}
+	return nil
+}
+
+// publishOutputs renames the temporary files of every output created in this
+// run to their final names
+func (cp *ChromosomeProcessor) publishOutputs() error {
+	if cp.opts.Append {
+		return nil
+	}
+	for chr := range cp.out.created {
+		if err := os.Rename(cp.TempPath(chr), cp.OutputPath(chr)); err != nil {
+			return fmt.Errorf("failed to finish output file %s: %v", cp.OutputPath(chr), err)
+		}
+	}
+	return nil
+}
+
+// discardOutputs removes the temporary files of a failed run, so no
+// incomplete output is left behind under a final name. In append mode the
+// output files are truncated back to their size before the run instead, and
+// those the run created are removed.
+func (cp *ChromosomeProcessor) discardOutputs() {
+	cp.CloseAllFiles()
+	for chr := range cp.out.created {
+		if !cp.opts.Append {
+			os.Remove(cp.TempPath(chr))
+			continue
+		}
+		if size, existed := cp.out.appendBase[chr]; existed {
+			os.Truncate(cp.OutputPath(chr), size)
+		} else {
+			os.Remove(cp.OutputPath(chr))
+		}
+	}
+}
+
+// closeOutput flushes and closes one open output: the buffer is flushed
+// first, then the gzip or zstd layer is closed so its trailer or last frame
+// reaches the file, and only then is the file itself closed. With a
+// checkpoint the file is synced before it is closed.
+func (cp *ChromosomeProcessor) closeOutput(chr string) error {
+	var firstErr error
+	cp.logf("Closing %s (flushing %d buffered bytes)", cp.TempPath(chr), cp.out.writers[chr].Buffered())
+	if err := cp.out.writers[chr].Flush(); err != nil {
+		firstErr = fmt.Errorf("failed to flush output for %s: %v", chr, err)
+	}
+	if zw, ok := cp.out.compressors[chr]; ok {
+		if err := zw.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to finish %s output for %s: %v", cp.outputCompression(), chr, err)
+		}
+	}
+	// a checkpoint or a stream's SyncAllFiles vouches for the records of
+	// outputs closed before it too, e.g. evicted by MaxOpenFiles
+	if cp.opts.Checkpoint != "" || cp.streamName != "" {
+		if err := cp.out.files[chr].Sync(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to sync output file for %s: %v", chr, err)
+		}
+	}
+	if err := cp.out.files[chr].Close(); err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("failed to close output file for %s: %v", chr, err)
+	}
+
+	cp.out.lru.Remove(cp.out.lruElems[chr])
+	delete(cp.out.lruElems, chr)
+	delete(cp.out.writers, chr)
+	delete(cp.out.compressors, chr)
+	delete(cp.out.files, chr)
+	return firstErr
+}
@@ -0,0 +1,34 @@
+package chrsplit
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestFailedAppendRestoresOutputs(t *testing.T) {
+	dir := t.TempDir()
+	first := writeInput(t, "day1.jsonl", []string{`{"chr":"chr1","pos":1}`, `{"chr":"chrUn","pos":2}`}, "\n")
+	cp := NewChromosomeProcessor([]string{first}, "out", "chr", []string{"chr1"}, Options{OutputDir: dir})
+	if err := cp.ProcessFile(); err != nil {
+		t.Fatal(err)
+	}
+	before := map[string][]string{"chr1": readOutput(t, cp, "chr1"), UnknownChr: readOutput(t, cp, UnknownChr)}
+
+	// the invalid line fails the run once every output was written to
+	second := writeInput(t, "day2.jsonl", append(testRecords(8), "{not json"), "\n")
+	cp = NewChromosomeProcessor([]string{second}, "out", "chr", testChromosomes, Options{OutputDir: dir, Append: true, Validate: true})
+	if err := cp.ProcessFile(); err == nil {
+		t.Fatal("the append run succeeded despite an invalid line")
+	}
+	for chr, want := range before {
+		if got := readOutput(t, cp, chr); !slices.Equal(got, want) {
+			t.Errorf("%s after the failed append: got %q, want %q", chr, got, want)
+		}
+	}
+	for _, chr := range []string{"chr2", "chrX"} {
+		if _, err := os.Stat(cp.OutputPath(chr)); !os.IsNotExist(err) {
+			t.Errorf("the failed append left %s, which it created", cp.OutputPath(chr))
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package chrsplit
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// OversizeChr is the output bucket for records longer than MaxRecordBytes
+// under the OversizeRoute policy
+const OversizeChr = "oversize"
+
+// oversize policies accepted by --oversize-policy
+const (
+	OversizeError = "error"
+	OversizeSkip  = "skip"
+	OversizeRoute = "route-to-file"
+)
+
+// oversizePreviewBytes is how much of an oversize record the error shows
+const oversizePreviewBytes = 200
+
+// ValidateOversizePolicy checks a --oversize-policy value
+func ValidateOversizePolicy(policy string) error {
+	switch policy {
+	case OversizeError, OversizeSkip, OversizeRoute:
+		return nil
+	}
+	return fmt.Errorf("unsupported oversize policy %q (expected error, skip or route-to-file)", policy)
+}
+
+// checkOversize counts a record routed to OversizeChr or, under the
+// OversizeError policy, fails the run with a preview of the record
+func (cp *ChromosomeProcessor) checkOversize(line []byte, name string, lineNum int) error {
+	if cp.opts.OversizePolicy == OversizeError || cp.opts.OversizePolicy == "" {
+		preview := strconv.Quote(string(line[:min(len(line), oversizePreviewBytes)]))
+		if len(line) > oversizePreviewBytes {
+			preview += "..."
+		}
+		return fmt.Errorf("record of %d bytes at %s %s exceeds the limit of %d bytes: %s",
+			len(line), name, cp.recordPos(lineNum), cp.opts.MaxRecordBytes, preview)
+	}
+	cp.tally.oversize++
+	return nil
+}
+
+// Oversize returns the number of records longer than MaxRecordBytes that
+// were skipped or routed to the OversizeChr output in the last ProcessFile call
+func (cp *ChromosomeProcessor) Oversize() int {
+	return cp.tally.oversize
+}
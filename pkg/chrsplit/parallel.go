@@ -0,0 +1,192 @@
+package chrsplit
+
+import (
+	"fmt"
+)
+
+// batchLines is the number of lines handed to a routing worker at once
+const batchLines = 4096
+
+// lineBatch is a block of input lines routed by one worker. The scanner
+// reuses its buffer, so the lines are copied into data.
+type lineBatch struct {
+	data     []byte
+	ends     []int
+	lineNums []int
+	chrs     []string
+	ready    chan struct{}
+	// counts are the records of the batch per output, tallied by the
+	// worker when countsInWorkers; chrs then only holds the special routes,
+	// at the lines of special
+	counts  map[string]int
+	special []int
+}
+
+func newLineBatch() *lineBatch {
+	return &lineBatch{
+		data:     make([]byte, 0, 1024*1024),
+		ends:     make([]int, 0, batchLines),
+		lineNums: make([]int, 0, batchLines),
+		ready:    make(chan struct{}),
+	}
+}
+
+func (b *lineBatch) add(line []byte, lineNum int) {
+	b.data = append(b.data, line...)
+	b.ends = append(b.ends, len(b.data))
+	b.lineNums = append(b.lineNums, lineNum)
+}
+
+func (b *lineBatch) line(i int) []byte {
+	start := 0
+	if i > 0 {
+		start = b.ends[i-1]
+	}
+	return b.data[start:b.ends[i]]
+}
+
+// processParallel reads lines on one goroutine, extracts chromosomes on
+// cp.opts.Workers goroutines and writes on the calling goroutine. Batches are
+// written in input order, so the order within every output file is the same
+// as with a single worker. Line numbers continue after the lineNum lines
+// already read. It returns the number of lines read and routed.
+func (cp *ChromosomeProcessor) processParallel(scanner recordScanner, name string, lineNum int) (int, int, error) {
+	workers := cp.opts.Workers
+	jobs := make(chan *lineBatch, workers)
+	ordered := make(chan *lineBatch, workers*2)
+	done := make(chan struct{})
+	readerDone := make(chan struct{})
+	// the caller closes the input once this returns, so the reader must have
+	// stopped scanning it by then
+	defer func() {
+		close(done)
+		<-readerDone
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for batch := range jobs {
+				if cp.countsInWorkers() {
+					cp.countBatch(batch)
+					close(batch.ready)
+					continue
+				}
+				batch.chrs = make([]string, len(batch.ends))
+				for j := range batch.ends {
+					batch.chrs[j] = cp.routeLine(batch.line(j))
+				}
+				close(batch.ready)
+			}
+		}()
+	}
+
+	// batches are queued on ordered before being handed to the workers, so
+	// the writer below consumes them in input order
+	go func() {
+		defer close(readerDone)
+		defer close(jobs)
+		defer close(ordered)
+
+		batch := newLineBatch()
+		send := func() bool {
+			select {
+			case ordered <- batch:
+			case <-done:
+				return false
+			}
+			select {
+			case jobs <- batch:
+			case <-done:
+				return false
+			}
+			batch = newLineBatch()
+			return true
+		}
+
+		for scanner.Scan() {
+			lineNum++
+			line := scanner.Bytes()
+			if cp.skipLine() || len(line) == 0 {
+				continue
+			}
+			batch.add(line, lineNum)
+			if len(batch.ends) == batchLines && !send() {
+				return
+			}
+		}
+		if len(batch.ends) > 0 {
+			send()
+		}
+	}()
+
+	routed := 0
+	for batch := range ordered {
+		if cp.interrupted() {
+			// the reader may be scanning ahead: it is stopped and waited
+			// for on return, and lineNum belongs to it until then
+			return batch.lineNums[0] - 1, routed, ErrInterrupted
+		}
+		<-batch.ready
+		if batch.counts != nil {
+			for chr, n := range batch.counts {
+				cp.counts[chr] += n
+			}
+			cp.progressLines.Add(int64(len(batch.ends) - len(batch.special)))
+			routed += len(batch.ends) - len(batch.special)
+			for k, i := range batch.special {
+				chr, err := cp.checkLine(batch.chrs[k], batch.line(i), name, batch.lineNums[i])
+				if err != nil {
+					return batch.lineNums[i], routed, err
+				}
+				if err := cp.writeLine(chr, batch.line(i)); err != nil {
+					return batch.lineNums[i], routed, fmt.Errorf("%v at %s %s", err, name, cp.recordPos(batch.lineNums[i]))
+				}
+				routed++
+			}
+			continue
+		}
+		for i, chr := range batch.chrs {
+			chr, err := cp.checkLine(chr, batch.line(i), name, batch.lineNums[i])
+			if err != nil {
+				return batch.lineNums[i], routed, err
+			}
+			if err := cp.writeLine(chr, batch.line(i)); err != nil {
+				return batch.lineNums[i], routed, fmt.Errorf("%v at %s %s", err, name, cp.recordPos(batch.lineNums[i]))
+			}
+			routed++
+			if cp.routedLine() {
+				return batch.lineNums[i], routed, nil
+			}
+		}
+	}
+
+	// ordered is closed once the reader returned, so lineNum is final
+	return lineNum, routed, nil
+}
+
+// countsInWorkers reports whether the routing workers count the records of
+// a CountOnly run themselves, which needs every record to be counted the
+// same whatever its place in the input: no header, line limit, sampled
+// unknown records, random sampling, deduplication or field statistics
+func (cp *ChromosomeProcessor) countsInWorkers() bool {
+	return cp.opts.CountOnly && cp.opts.DryRun && !cp.tracksHeader() && cp.opts.JSONHeaderLines == 0 &&
+		!cp.isGFF() && cp.opts.MaxLines == 0 && cp.opts.SampleUnknown == 0 &&
+		!cp.opts.NoUnknown && !cp.opts.DropUnknown && !cp.opts.Dedup && cp.opts.SampleRate == 0 &&
+		cp.opts.PerChrSample == 0 && cp.opts.StatsField == ""
+}
+
+// countBatch routes the lines of a batch and tallies them per output;
+// lines going to a special output are left to the writer
+func (cp *ChromosomeProcessor) countBatch(batch *lineBatch) {
+	batch.counts = make(map[string]int)
+	for j := range batch.ends {
+		chr := cp.routeLine(batch.line(j))
+		switch chr {
+		case commentChr, headerChr, filteredChr, regionChr, fastaStartChr, FastaChr, MalformedChr, OversizeChr:
+			batch.chrs = append(batch.chrs, chr)
+			batch.special = append(batch.special, j)
+		default:
+			batch.counts[chr]++
+		}
+	}
+}
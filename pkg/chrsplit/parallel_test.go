@@ -0,0 +1,81 @@
+package chrsplit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestProcessParallelMaxLines(t *testing.T) {
+	records := testRecords(50000)
+	input := writeInput(t, "in.jsonl", records, "\n")
+	for _, mmap := range []bool{false, true} {
+		cp := runSplit(t, []string{input}, Options{Workers: 4, MaxLines: 5000, Mmap: mmap})
+		if got := readOutputs(t, cp); !sameRecords(got, records[:5000]) {
+			t.Errorf("mmap %v: got %d records, want the first 5000", mmap, len(got))
+		}
+	}
+}
+
+func TestProcessParallelStop(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	cp := NewChromosomeProcessor([]string{StdinInput}, "out", "chr", testChromosomes, Options{Workers: 4, OutputDir: t.TempDir()})
+
+	// the input never ends: the run only returns because of Stop, which is
+	// called once the run has been fed for a while
+	quit := make(chan struct{})
+	go func() {
+		defer w.Close()
+		records := testRecords(1000)
+		for sent := 0; ; sent += len(records) {
+			for _, record := range records {
+				if _, err := io.WriteString(w, record+"\n"); err != nil {
+					return
+				}
+			}
+			if sent == 50000 {
+				cp.Stop()
+			}
+			select {
+			case <-quit:
+				return
+			default:
+			}
+		}
+	}()
+	defer close(quit)
+
+	if err := cp.ProcessFile(); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("ProcessFile after Stop: got %v, want %v", err, ErrInterrupted)
+	}
+}
+
+func BenchmarkProcessParallel(b *testing.B) {
+	input := writeInput(b, "in.jsonl", testRecords(200000), "\n")
+	info, err := os.Stat(input)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			opts := Options{Workers: workers, OutputDir: b.TempDir(), Force: true}
+			b.SetBytes(info.Size())
+			for i := 0; i < b.N; i++ {
+				cp := NewChromosomeProcessor([]string{input}, "out", "chr", testChromosomes, opts)
+				if err := cp.ProcessFile(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package chrsplit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the suffixes accepted by ParseSize, longest first
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+	{"B", 1},
+}
+
+// ParseSize parses a byte size such as "500MB", "2GiB" or "1048576"; it must
+// come to at least one byte and fit in an int64
+func ParseSize(size string) (int64, error) {
+	return parseSize(size, false)
+}
+
+// parseSize parses a size like ParseSize, also accepting 0 with allowZero
+func parseSize(size string, allowZero bool) (int64, error) {
+	s := strings.TrimSpace(size)
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(unit.suffix)) {
+			s, factor = strings.TrimSpace(s[:len(s)-len(unit.suffix)]), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 500MB, 2GiB or a number of bytes)", size)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, the first size that overflows
+	bytes := n * float64(factor)
+	if bytes >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("size %q is too large", size)
+	}
+	if bytes < 0 || (int64(bytes) == 0 && !allowZero) {
+		return 0, fmt.Errorf("size %q must be at least one byte", size)
+	}
+	return int64(bytes), nil
+}
+
+// OutputPart is one file of a chromosome's output split by MaxFileSize or
+// MaxLinesPerFile
+type OutputPart struct {
+	Path  string `json:"file"`
+	Lines int    `json:"lines"`
+}
+
+// partKey returns the output name of part n of chr: the chromosome itself
+// for the first part, then e.g. "chr1.part0002", so the later parts are
+// named prefix_chr1.part0002.jsonl by OutputPath
+func partKey(chr string, n int) string {
+	if n <= 1 {
+		return chr
+	}
+	return fmt.Sprintf("%s.part%04d", chr, n)
+}
+
+// currentPart returns the output name the next line of chr goes to. With
+// MaxFileSize, when the line would take the current part over the limit, the
+// part is closed and the next one started, so parts always end on a line
+// boundary; a single line larger than the limit gets a part of its own. With
+// MaxLinesPerFile, a part is likewise closed once it holds that many lines.
+func (cp *ChromosomeProcessor) currentPart(chr string, lineBytes int) (string, error) {
+	if !cp.splitsParts() {
+		return chr, nil
+	}
+	n := max(cp.parts[chr], 1)
+	size := cp.partBytes[chr]
+	full := cp.opts.MaxFileSize > 0 && size > 0 && size+int64(lineBytes) > cp.opts.MaxFileSize
+	if cp.opts.MaxLinesPerFile > 0 && cp.partLines[partKey(chr, n)] >= cp.opts.MaxLinesPerFile {
+		full = true
+	}
+	if full {
+		if _, open := cp.out.files[partKey(chr, n)]; open {
+			if err := cp.closeOutput(partKey(chr, n)); err != nil {
+				return "", err
+			}
+		}
+		n++
+		cp.partBytes[chr] = 0
+	}
+	cp.parts[chr] = n
+	cp.partBytes[chr] += int64(lineBytes)
+	cp.partLines[partKey(chr, n)]++
+	return partKey(chr, n), nil
+}
+
+// splitsParts reports whether outputs are split into parts
+func (cp *ChromosomeProcessor) splitsParts() bool {
+	return cp.opts.MaxFileSize > 0 || cp.opts.MaxLinesPerFile > 0
+}
+
+// OutputParts returns the files written for chr in the last ProcessFile call
+// with their line counts; without MaxFileSize or MaxLinesPerFile there is
+// one, OutputPath(chr)
+func (cp *ChromosomeProcessor) OutputParts(chr string) []OutputPart {
+	if !cp.splitsParts() || cp.parts[chr] <= 1 {
+		return []OutputPart{{Path: cp.OutputPath(chr), Lines: cp.counts[chr]}}
+	}
+	parts := make([]OutputPart, 0, cp.parts[chr])
+	for n := 1; n <= cp.parts[chr]; n++ {
+		key := partKey(chr, n)
+		parts = append(parts, OutputPart{Path: cp.OutputPath(key), Lines: cp.partLines[key]})
+	}
+	return parts
+}
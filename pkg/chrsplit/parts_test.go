@@ -0,0 +1,35 @@
+package chrsplit
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+	}{
+		{"1", 1},
+		{"1048576", 1048576},
+		{"500MB", 500e6},
+		{"2GiB", 2 << 30},
+		{" 64 kib ", 64 << 10},
+		{"1.5K", 1500},
+		{"1e3", 1000},
+		{"8388607TiB", 8388607 << 40},
+	}
+	for _, tt := range tests {
+		if got, err := ParseSize(tt.size); err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.size, got, err, tt.want)
+		}
+	}
+
+	for _, size := range []string{
+		"", "MB", "abc", "1.2.3",
+		"NaN", "nan", "NaNMB", "Inf", "+Inf", "-Inf", "infinity", "InfGB",
+		"0", "0B", "0MB", "-1", "-1MB", "-0.5", "0.4", "0.0001KB",
+		"8388608TiB", "9223372036854775807", "1e19", "1e30TB", "1e308TB",
+	} {
+		if got, err := ParseSize(size); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", size, got)
+		}
+	}
+}
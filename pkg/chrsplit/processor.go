@@ -0,0 +1,1296 @@
+// Package chrsplit splits a JSONL/NDJSON stream into one file per chromosome.
+package chrsplit
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// UnknownChr is the default name of the output bucket for records whose
+// chromosome is missing or not in the target list (see UnknownName)
+const UnknownChr = "unknown_chr"
+
+// ErrInterrupted is returned by ProcessFile when Stop ended the run early
+var ErrInterrupted = errors.New("interrupted")
+
+// MalformedChr is the output bucket for lines that are not valid JSON, used
+// in strict mode
+const MalformedChr = "malformed"
+
+// maxMalformedReported caps the number of malformed line positions kept
+const maxMalformedReported = 100
+
+// MalformedLine is the position of a line that is not valid JSON
+type MalformedLine struct {
+	Input string `json:"input"`
+	Line  int    `json:"line"`
+}
+
+// outputSet is the open outputs of a run and what the run did to their files
+type outputSet struct {
+	writers     map[string]*bufio.Writer
+	files       map[string]*os.File
+	compressors map[string]compressWriter
+	// lru orders the open outputs by last write, for MaxOpenFiles
+	lru      *list.List
+	lruElems map[string]*list.Element
+	// created is the outputs the run has opened so far
+	created map[string]bool
+	// appendBase is the size an appended output had before the run
+	appendBase map[string]int64
+}
+
+// runCounts is the per-run line counters reported in the summary
+type runCounts struct {
+	malformed  int
+	oversize   int
+	comment    int
+	filtered   int
+	dropped    int
+	dedup      int
+	fasta      int
+	jsonHeader int
+	routed     int
+	// unknownSampled is the unknown lines kept in the malformed sample
+	unknownSampled int
+	// skipLeft is the leading SkipLines lines still to be skipped
+	skipLeft int
+}
+
+// checkpointState is where the run is in its inputs and when it last
+// wrote a Checkpoint
+type checkpointState struct {
+	input int
+	pos   checkpoint
+	since int
+	last  time.Time
+	// resumeAt is the checkpoint the run resumes from, if any
+	resumeAt *checkpoint
+}
+
+// headerState is the input header lines and which outputs have them
+type headerState struct {
+	region  map[string][][]byte
+	lines   [][]byte
+	done    bool
+	written map[string]bool
+}
+
+// ChromosomeProcessor is a processor for chromosome-specific JSONL files
+type ChromosomeProcessor struct {
+	inputFiles    []string
+	prefix        string
+	chrFieldName  string
+	unknownChr    string
+	chrIndex      int
+	chrPath       []string
+	posPath       []string
+	posIndex      int
+	chrNames      []string
+	chrSet        map[string]bool
+	aliases       map[string]string
+	out           outputSet
+	counts        map[string]int
+	inputStats    []InputStat
+	malformed     []MalformedLine
+	tally         runCounts
+	dedupSets     map[string]map[uint64]struct{}
+	fieldStats    map[string]map[string]int
+	dedupSeed     maphash.Seed
+	streamName    string
+	streamN       int
+	ckpt          checkpointState
+	inFASTA       bool
+	hdr           headerState
+	rowBuf        []byte
+	keepFields    []string
+	keepKeys      [][]byte
+	parts         map[string]int
+	partBytes     map[string]int64
+	partLines     map[string]int
+	indexes       map[string]*outputIndex
+	routedBytes   map[string]int64
+	sorters       map[string]*outputSorter
+	sortDir       string
+	pathOwners    map[string]string
+	scanned       map[string]int
+	rng           *rand.Rand
+	reservoirs    map[string]*reservoir
+	opts          Options
+	stop          chan struct{}
+	stopOnce      sync.Once
+	stopped       atomic.Bool
+	progressLines atomic.Int64
+	progressBytes atomic.Int64
+}
+
+// Options holds the optional settings of a ChromosomeProcessor
+type Options struct {
+	// InputCompression is one of CompressionAuto, CompressionGzip or CompressionNone
+	InputCompression string
+	// InputEncoding is the text encoding of the inputs: EncodingAuto (UTF-8
+	// unless a UTF-16 byte order mark says otherwise), EncodingUTF8,
+	// EncodingUTF16LE or EncodingUTF16BE. Outputs are always UTF-8.
+	InputEncoding string
+	// Format is the record format of the inputs: FormatJSONL (one record
+	// per line, the default when empty), FormatConcatJSON (back-to-back
+	// JSON values), FormatPretty (the same, for pretty-printed objects,
+	// which are all minified), FormatJSONArray (the elements of one
+	// top-level array), FormatJSONSeq (RFC 7464), FormatMsgpack or one of
+	// the text formats; in the JSON formats but FormatJSONL and in
+	// FormatMsgpack, line numbers count records
+	Format string
+	// OutputFormat is the record format of the outputs: FormatJSONL (the
+	// default when empty) or FormatJSONSeq, which frames every record with a
+	// record separator and a newline (files named .json-seq), or FormatTSV,
+	// which writes the Fields of each record as a tab-separated row (.tsv)
+	OutputFormat string
+	// KeepFields projects every record on these gjson paths: an object with
+	// only these fields (and always the chromosome field) is written instead
+	// of the line
+	KeepFields []string
+	// Fields are the gjson paths written as columns by FormatTSV; a missing
+	// field is an empty column
+	Fields []string
+	// Logger receives verbose events: inputs opened and finished, output
+	// files opened, flushed and closed. Nil disables them.
+	Logger *log.Logger
+	// SampleUnknown is the number of records routed to UnknownChr that are
+	// logged to SampleLogger, with their chromosome value
+	SampleUnknown int
+	// SampleLogger receives the SampleUnknown samples
+	SampleLogger *log.Logger
+	// ChrColumn is the header row name of the chromosome column of TSV or
+	// CSV input, whose first row is then the header
+	ChrColumn string
+	// ChrColumnIndex is the chromosome column (from 1) of headerless TSV or
+	// CSV input, used when ChrColumn is empty
+	ChrColumnIndex int
+	// Delimiter is the field delimiter of TSV or CSV input, instead of a tab
+	// or a comma
+	Delimiter byte
+	// SkipLines is the number of lines at the start of the inputs that are
+	// read and discarded; line numbers stay those of the inputs
+	SkipLines int
+	// MaxLines stops the run, as if the inputs ended, once this many lines
+	// were routed; 0 means no limit
+	MaxLines int
+	// SortBuffer sorts the records of every output by PosFieldName position
+	// when > 0, keeping the input order of equal positions and putting
+	// records without a position last. Up to SortBuffer bytes of records
+	// per output are held in memory, then spilled as a sorted run to a
+	// temporary file next to the outputs; the runs are merged into the
+	// outputs at the end of the run.
+	SortBuffer int64
+	// SampleRate keeps each record with this probability (0 < SampleRate
+	// <= 1) and drops the others; 0 keeps every record. The draws come
+	// from a generator seeded with Seed, in input order, so a run is
+	// reproducible.
+	SampleRate float64
+	Seed       int64
+	// PerChrSample keeps exactly this many records of every output (all of
+	// them when it has fewer), chosen uniformly at random by reservoir
+	// sampling with the generator seeded with Seed. The kept records are
+	// held in memory and written, in input order, at the end of the run.
+	PerChrSample int
+	// Dedup skips a record identical to one already routed to the same
+	// output. Every output keeps a set of 64-bit hashes of its records for
+	// the whole run, about 40 bytes of memory per distinct record.
+	Dedup bool
+	// DedupField is the gjson path of the field Dedup compares instead of
+	// the whole line, e.g. a variant ID
+	DedupField string
+	// StatsField is the gjson path of a field whose values are tallied per
+	// output over the records routed, e.g. a variant type; see FieldStats
+	StatsField string
+	// MergeSorted reads the inputs side by side, each sorted by chromosome
+	// (in natural order) and PosFieldName position, and routes their
+	// records in merged order on one goroutine, so every output is sorted
+	// too; a record out of order in its input fails the run
+	MergeSorted bool
+	// Mmap reads uncompressed local inputs through a read-only memory
+	// mapping, slicing the lines out of it instead of copying them into a
+	// read buffer; other inputs are read as usual
+	Mmap bool
+	// Checkpoint is a file recording the progress of the run every
+	// CheckpointLines records or CheckpointInterval, with the outputs
+	// synced to disk, so an interrupted run can be continued with Resume.
+	// It needs local uncompressed inputs and a single worker.
+	Checkpoint         string
+	CheckpointLines    int
+	CheckpointInterval time.Duration
+	// Resume continues the run recorded in the Checkpoint file: its outputs
+	// are truncated back to their sizes at the checkpoint and appended to,
+	// and reading starts where the checkpoint was taken
+	Resume bool
+	// UnknownName names the output of the records whose chromosome is
+	// missing or not a target, instead of UnknownChr
+	UnknownName string
+	// DropUnknown counts the records that would go to UnknownChr instead of
+	// writing them; the UnknownChr output is not created
+	DropUnknown bool
+	// NoUnknown fails the run on the first record that would go to
+	// UnknownChr, telling an out-of-set value from a missing field
+	NoUnknown bool
+	// Gzip writes gzip-compressed output files (.jsonl.gz); it is the same
+	// as Compress CompressionGzip
+	Gzip bool
+	// Compress is the compression of the output files: CompressionNone,
+	// CompressionGzip (.gz), CompressionBGZF (.gz) or CompressionZstd
+	// (.zst). Empty follows Gzip.
+	Compress string
+	// CompressLevel is the gzip or BGZF (1-9) or zstd (1-22) level; 0 uses the
+	// default of the compression
+	CompressLevel int
+	// CompressThreads is the number of goroutines compressing the outputs,
+	// in total: outputs are written one at a time, each compressing up to
+	// CompressThreads blocks at once. 0 means 1.
+	CompressThreads int
+	// ChrFieldRaw treats the chromosome field name as one literal top-level
+	// key rather than a gjson path, so dots and wildcards need no escaping
+	ChrFieldRaw bool
+	// Normalize matches chromosome values ignoring a "chr" prefix and case,
+	// so "1", "Chr1" and "CHR1" all land in the target named "chr1"
+	Normalize bool
+	// IgnoreCase matches chromosome values case-insensitively; outputs keep
+	// the case of the target names, so "ChrX" and "chrx" land in "chrX"
+	IgnoreCase bool
+	// MitoAliases are the (prefix-less) names treated as the mitochondrial
+	// chromosome when normalizing, e.g. "M" and "MT"
+	MitoAliases []string
+	// Dynamic creates an output for every distinct value of the field
+	// instead of routing to a fixed list of chromosomes; the list passed to
+	// NewChromosomeProcessor is ignored
+	Dynamic bool
+	// MaxOpenFiles caps the number of output files open at once; the least
+	// recently used one is closed (and later reopened for appending) when
+	// another is needed. 0 uses DefaultMaxOpenFiles.
+	MaxOpenFiles int
+	// Follow keeps reading a growing (uncompressed, local) input at its end,
+	// like `tail -f`, until the sentinel line, IdleTimeout or Stop;
+	// lines are routed on the calling goroutine whatever Workers says
+	Follow bool
+	// FollowSentinel is a line that ends a followed input when it is read
+	FollowSentinel string
+	// IdleTimeout ends a followed input after this long without new data;
+	// 0 waits until the sentinel or Stop
+	IdleTimeout time.Duration
+	// Strict checks that every line is valid JSON. Invalid lines are counted
+	// apart from UnknownChr and their positions are reported; they are only
+	// written (to the MalformedChr output) with WriteMalformed.
+	Strict bool
+	// WriteMalformed writes the invalid lines found in strict mode to the
+	// MalformedChr output instead of dropping them
+	WriteMalformed bool
+	// Validate checks that every line is valid JSON like Strict, but keeps
+	// routing invalid lines as before; it only counts and reports them
+	Validate bool
+	// MaxInvalidFraction fails the run when Validate is set and more than
+	// this fraction (0 to 1) of the lines are not valid JSON
+	MaxInvalidFraction float64
+	// CommentPrefixes lists line prefixes (e.g. "#", "//") marking comment
+	// lines, which are counted and skipped before any parsing
+	CommentPrefixes []string
+	// KeepCommentHeader copies the comment lines before the first record
+	// into every output (see HeaderPrefix)
+	KeepCommentHeader bool
+	// HeaderPrefix marks header lines (e.g. "##" for a VCF-style preamble):
+	// those before the first record are written at the top of every output,
+	// however late it is created, and are not counted as records
+	HeaderPrefix string
+	// JSONHeaderLines makes the first records of the run header records,
+	// e.g. 1 for a metadata object on line 1: like HeaderPrefix lines they
+	// are written at the top of every output instead of being routed
+	JSONHeaderLines int
+	// HeaderTypeField and HeaderTypeValue mark header records by content
+	// instead: a record whose HeaderTypeField (a gjson path) equals
+	// HeaderTypeValue, before the first record of the run, is a header record
+	HeaderTypeField string
+	HeaderTypeValue string
+	// FilterField, FilterOp and FilterValue drop the records whose field
+	// (a gjson path) does not compare to the value with the op (FilterEq,
+	// FilterNe, FilterGt or FilterLt) before they are routed
+	FilterField string
+	FilterOp    string
+	FilterValue string
+	// MaxFileSize starts a new numbered part of a chromosome's output
+	// (prefix_chr1.part0002.jsonl, ...) before a line would take the current
+	// one over this many (uncompressed) bytes; 0 means no limit
+	MaxFileSize int64
+	// BinSize splits every chromosome further into bins of this many
+	// positions (prefix_chr1_bin0000123.jsonl for pos / BinSize = 123);
+	// records without a valid position go to prefix_chr1_nopos.jsonl
+	BinSize int64
+	// IndexEvery writes an index file next to every (uncompressed) output,
+	// with its position range and the position and byte offset of every
+	// IndexEvery-th record; 0 writes none
+	IndexEvery int
+	// PosFieldName is the gjson path of the position field used by BinSize
+	// and IndexEvery
+	PosFieldName string
+	// MaxLinesPerFile starts a new numbered part of a chromosome's output
+	// once the current one holds this many lines; 0 means no limit
+	MaxLinesPerFile int
+	// MaxRecordBytes is the longest line (without its newline) routed as
+	// usual; 0 means no limit. Longer records are handled by OversizePolicy.
+	MaxRecordBytes int
+	// OversizePolicy is OversizeError (the default: fail with the position
+	// and start of the record), OversizeSkip or OversizeRoute (write to the
+	// OversizeChr output)
+	OversizePolicy string
+	// Append adds the lines of this run to the end of existing output files
+	// instead of replacing them. The files are written in place; a failed
+	// run truncates them back to their previous size.
+	Append bool
+	// RangeStart and RangeEnd, when RangeEnd > 0, limit the run to the
+	// records of a local uncompressed input that start at a byte offset
+	// in [RangeStart, RangeEnd), so instances given adjacent ranges split
+	// one input between them; see SplitRanges
+	RangeStart int64
+	RangeEnd   int64
+	// Shard, when > 0, adds .shardNNN to the output names, so instances
+	// splitting parts of the same data never write the same files
+	Shard int
+	// Force overwrites existing output files; without it ProcessFile fails
+	// before reading any input when one of them exists, and an output
+	// created later in the run fails to open when its file exists by then
+	Force bool
+	// DryRun scans and counts every line without creating or writing any
+	// output file
+	DryRun bool
+	// RecordDelimiter is the byte ending every JSONL record, in the inputs
+	// and in the outputs, instead of a newline: e.g. "\x00" or "\x1e".
+	// Empty means a newline.
+	RecordDelimiter string
+	// FilenameTemplate names the output files instead of
+	// prefix_chr.jsonl: {prefix}, {chr}, {input} (the first input's file
+	// name without extensions) and {part} (the part number) are replaced,
+	// and the result is joined to OutputDir; see ValidateFilenameTemplate
+	FilenameTemplate string
+	// CountOnly speeds up a DryRun that is only after the counts per
+	// output: with Workers > 1 the routing workers count the records
+	// themselves instead of handing every line back to be counted
+	CountOnly bool
+	// Workers is the number of goroutines extracting chromosomes; 0 or 1
+	// processes lines on the calling goroutine
+	Workers int
+	// DecompressThreads is the number of goroutines inflating BGZF input
+	DecompressThreads int
+	// HTTPTimeout bounds connecting to an HTTP(S) input and waiting for
+	// the response headers
+	HTTPTimeout time.Duration
+	// HTTPRetries is the number of times a failed HTTP(S) transfer is
+	// retried (resuming with a Range request) before giving up
+	HTTPRetries int
+	// MemberPatterns selects the members of a tar or zip input that are processed,
+	// matched against the member's base name; nil uses DefaultInputPatterns
+	MemberPatterns []string
+	// GCSReadChunkSize is the size of each ranged read of a gs:// input;
+	// 0 uses DefaultGCSReadChunkSize
+	GCSReadChunkSize int64
+	// OutputDir is the directory the output files are written to; it is
+	// created if missing. Empty means the current working directory.
+	OutputDir string
+}
+
+// InputStat is the number of lines routed from one input file, or from one
+// member of a tar or zip archive (named "archive:member")
+type InputStat struct {
+	Input string `json:"input"`
+	Lines int    `json:"lines"`
+	// Bytes is the number of (compressed) bytes read from the source
+	Bytes int64 `json:"bytes"`
+}
+
+// NewChromosomeProcessor is the constructor for ChromosomeProcessor. The
+// inputs are read one after another into a single set of output files.
+// chrFieldName is a gjson path, so "variant.location.chr" addresses a nested
+// field and a literal dot in a key is written as "\."; with the ChrFieldRaw
+// option it is taken as a single top-level key instead.
+func NewChromosomeProcessor(inputFiles []string, prefix, chrFieldName string, chrNames []string, opts Options) *ChromosomeProcessor {
+	if opts.ChrFieldRaw {
+		chrFieldName = gjson.Escape(chrFieldName)
+	}
+
+	chrSet := make(map[string]bool)
+	for _, chr := range chrNames {
+		chrSet[chr] = true
+	}
+
+	keepFields, keepKeys := keptFields(chrFieldName, opts.KeepFields)
+	unknownChr := opts.UnknownName
+	if unknownChr == "" {
+		unknownChr = UnknownChr
+	}
+	return &ChromosomeProcessor{
+		inputFiles:   inputFiles,
+		prefix:       prefix,
+		chrFieldName: chrFieldName,
+		unknownChr:   unknownChr,
+		chrIndex:     opts.ChrColumnIndex - 1,
+		chrPath:      splitFieldPath(chrFieldName),
+		posPath:      splitFieldPath(opts.PosFieldName),
+		posIndex:     -1,
+		chrNames:     chrNames,
+		chrSet:       chrSet,
+		aliases:      buildAliases(chrNames, opts),
+		out: outputSet{
+			writers:     make(map[string]*bufio.Writer),
+			files:       make(map[string]*os.File),
+			compressors: make(map[string]compressWriter),
+			lru:         list.New(),
+			lruElems:    make(map[string]*list.Element),
+			created:     make(map[string]bool),
+			appendBase:  make(map[string]int64),
+		},
+		hdr:        headerState{written: make(map[string]bool)},
+		counts:     make(map[string]int),
+		keepFields: keepFields,
+		keepKeys:   keepKeys,
+		opts:       opts,
+		stop:       make(chan struct{}),
+	}
+}
+
+// outputExt returns the extension of the output files
+func (cp *ChromosomeProcessor) outputExt() string {
+	ext := ".jsonl"
+	if cp.isText() {
+		ext = cp.textExt()
+	} else if cp.isMsgpack() {
+		ext = ".msgpack"
+	} else if cp.opts.OutputFormat == FormatJSONSeq {
+		ext = ".json-seq"
+	} else if cp.opts.OutputFormat == FormatTSV {
+		ext = ".tsv"
+	}
+	return ext + cp.compressExt()
+}
+
+// OutputChromosomes returns the target chromosomes followed by UnmappedChr
+// (SAM inputs only) and UnknownChr (unless DropUnknown). In
+// dynamic mode these are the values seen by the last ProcessFile call, and
+// with BinSize the bins written to, sorted.
+func (cp *ChromosomeProcessor) OutputChromosomes() []string {
+	var chrs []string
+	if cp.lazyOutputs() {
+		chrs = make([]string, 0, len(cp.counts))
+		for chr := range cp.counts {
+			if chr != cp.unknownChr && chr != UnmappedChr {
+				chrs = append(chrs, chr)
+			}
+		}
+		sort.Strings(chrs)
+	} else {
+		chrs = make([]string, 0, len(cp.chrNames)+2)
+		chrs = append(chrs, cp.chrNames...)
+	}
+	if cp.isSAM() {
+		chrs = append(chrs, UnmappedChr)
+	}
+	if cp.opts.DropUnknown {
+		return chrs
+	}
+	return append(chrs, cp.unknownChr)
+}
+
+// SummaryChromosomes returns OutputChromosomes with the chromosomes in
+// natural order (chr1, chr2, ..., chr10, ..., chrX, chrY, chrM) and
+// UnmappedChr and UnknownChr still last
+func (cp *ChromosomeProcessor) SummaryChromosomes() []string {
+	chrs := cp.OutputChromosomes()
+	n := len(chrs)
+	for n > 0 && (chrs[n-1] == cp.unknownChr || chrs[n-1] == UnmappedChr) {
+		n--
+	}
+	return append(SortChromosomes(chrs[:n]), chrs[n:]...)
+}
+
+// OutputPath returns the path of the output file for the specified chromosome
+func (cp *ChromosomeProcessor) OutputPath(chr string) string {
+	if chr == FastaChr && cp.isGFF() {
+		name := fmt.Sprintf("%s_%s.fa", cp.prefix, FastaChr)
+		name += cp.compressExt()
+		return filepath.Join(cp.opts.OutputDir, name)
+	}
+	if cp.opts.FilenameTemplate != "" {
+		return cp.templatePath(chr)
+	}
+	return filepath.Join(cp.opts.OutputDir, fmt.Sprintf("%s_%s%s%s", cp.prefix, chr, cp.shardSuffix(), cp.outputExt()))
+}
+
+// TempPath returns the path an output file is written to until the run has
+// completed successfully and it is renamed to OutputPath. In append mode
+// the output file itself is written to.
+func (cp *ChromosomeProcessor) TempPath(chr string) string {
+	if cp.opts.Append {
+		return cp.OutputPath(chr)
+	}
+	return cp.OutputPath(chr) + ".tmp"
+}
+
+// InitializeOutputFiles creates output files for each chromosome. In dynamic
+// mode and with BinSize only the output directory is created; the files are created by
+// GetOutputWriter as new values show up.
+func (cp *ChromosomeProcessor) InitializeOutputFiles() error {
+	if err := cp.prepareOutputDir(); err != nil {
+		return err
+	}
+
+	cp.out.created = make(map[string]bool)
+	cp.out.appendBase = make(map[string]int64)
+	if cp.ckpt.resumeAt != nil {
+		if err := cp.resumeOutputs(); err != nil {
+			return err
+		}
+	}
+	if cp.lazyOutputs() {
+		return nil
+	}
+
+	for _, chr := range cp.OutputChromosomes() {
+		if _, err := cp.openOutput(chr); err != nil {
+			cp.CloseAllFiles()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prepareOutputDir creates the directory the outputs go to, the output
+// directory joined with any directories in the prefix, and checks that
+// files can be created in it, so an unwritable directory fails the run
+// before any input is read
+func (cp *ChromosomeProcessor) prepareOutputDir() error {
+	dir := filepath.Dir(cp.OutputPath(cp.unknownChr))
+	if cp.opts.FilenameTemplate != "" {
+		// the directories of a template are created with the outputs
+		dir = filepath.Join(cp.opts.OutputDir, ".")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %v", dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".chrsplit-")
+	if err != nil {
+		return fmt.Errorf("output directory %s is not writable: %v", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+// GetOutputWriter gets the output writer for the specified chromosome,
+// (re)opening its file when it is not open. Outside dynamic mode a chromosome
+// that is not a target falls back to UnknownChr.
+func (cp *ChromosomeProcessor) GetOutputWriter(chr string) (*bufio.Writer, error) {
+	if !cp.lazyOutputs() && !cp.chrSet[chr] && chr != MalformedChr && chr != OversizeChr && chr != UnmappedChr {
+		chr = cp.unknownChr
+	}
+	return cp.outputWriter(partKey(chr, cp.parts[chr]))
+}
+
+// outputWriter returns the writer of an output by its file name (see
+// partKey), reopening it if needed
+func (cp *ChromosomeProcessor) outputWriter(name string) (*bufio.Writer, error) {
+	if writer, exists := cp.out.writers[name]; exists {
+		cp.out.lru.MoveToFront(cp.out.lruElems[name])
+		return writer, nil
+	}
+	return cp.openOutput(name)
+}
+
+// ExtractChromosome extracts the chromosome information from one row: the
+// chromosome field, or column 1 of a text record (e.g. CHROM of a VCF;
+// RNAME, column 3, of a SAM alignment)
+func (cp *ChromosomeProcessor) ExtractChromosome(line []byte) (string, bool) {
+	if cp.isText() {
+		chrom, ok := cp.textColumn(line, cp.chrColumn())
+		return string(chrom), ok && len(chrom) > 0
+	}
+	if cp.isMsgpack() {
+		return msgpackField(line, cp.chrPath)
+	}
+	result := gjson.GetBytes(line, cp.chrFieldName)
+	if !result.Exists() {
+		return "", false
+	}
+	return result.String(), true
+}
+
+// routeLine returns the output chromosome for one line (or, with BinSize,
+// its position bin). It only reads the processor configuration, so it is
+// safe to call from several goroutines.
+func (cp *ChromosomeProcessor) routeLine(line []byte) string {
+	chr := cp.routeChromosome(line)
+	if cp.opts.BinSize > 0 {
+		return cp.binOutput(chr, line)
+	}
+	return chr
+}
+
+// routeChromosome returns the output chromosome (or special output) of one line
+func (cp *ChromosomeProcessor) routeChromosome(line []byte) string {
+	if cp.opts.HeaderPrefix != "" && bytes.HasPrefix(line, []byte(cp.opts.HeaderPrefix)) {
+		return headerChr
+	}
+	if cp.isGFF() {
+		if route := routeGFFDirective(line); route != "" {
+			return route
+		}
+	}
+	if cp.isText() && cp.isTextHeader(line) {
+		return headerChr
+	}
+	if cp.opts.HeaderTypeField != "" && gjson.GetBytes(line, cp.opts.HeaderTypeField).String() == cp.opts.HeaderTypeValue {
+		return headerChr
+	}
+	if len(cp.opts.CommentPrefixes) > 0 && cp.isComment(line) {
+		return commentChr
+	}
+	if cp.opts.MaxRecordBytes > 0 && len(line) > cp.opts.MaxRecordBytes {
+		return OversizeChr
+	}
+	if cp.opts.Strict || cp.opts.Validate {
+		switch {
+		case cp.isText():
+			if !cp.validTextRecord(line) {
+				return MalformedChr
+			}
+		case cp.isMsgpack():
+			if !isMsgpackMap(line) {
+				return MalformedChr
+			}
+		case !gjson.ValidBytes(line):
+			return MalformedChr
+		}
+	}
+	if cp.opts.FilterField != "" && !cp.matchesFilter(line) {
+		return filteredChr
+	}
+	chr, found := cp.ExtractChromosome(line)
+	if !found {
+		return cp.unknownChr
+	}
+	if cp.isSAM() && chr == samUnmapped {
+		return UnmappedChr
+	}
+	return cp.outputFor(chr)
+}
+
+// checkLine accounts for a line routed to one of the special outputs before
+// it is written: header, comment and filtered lines are collected or counted,
+// malformed lines are recorded, unknown records are sampled or, with
+// NoUnknown, fail the run, and an oversize record is counted or, under
+// the OversizeError policy, fails the run. It returns the output the line
+// goes to, which differs from chr for the header records of JSONHeaderLines
+// and for the lines of a GFF3 ##FASTA section.
+func (cp *ChromosomeProcessor) checkLine(chr string, line []byte, name string, lineNum int) (string, error) {
+	switch {
+	case cp.inFASTA:
+		chr = FastaChr
+	case chr == fastaStartChr:
+		cp.inFASTA = true
+	}
+	if cp.opts.JSONHeaderLines > 0 && !cp.hdr.done && cp.tally.jsonHeader < cp.opts.JSONHeaderLines &&
+		chr != commentChr && chr != headerChr {
+		chr = headerChr
+		cp.tally.jsonHeader++
+	}
+	if cp.tracksHeader() {
+		if err := cp.trackHeader(chr, line); err != nil {
+			return chr, err
+		}
+	}
+	switch chr {
+	case MalformedChr:
+		cp.recordMalformed(name, lineNum)
+	case OversizeChr:
+		if err := cp.checkOversize(line, name, lineNum); err != nil {
+			return chr, err
+		}
+	case filteredChr:
+		cp.tally.filtered++
+	case cp.unknownChr:
+		cp.sampleUnknown(line, name, lineNum)
+		if err := cp.checkUnknown(line, name, lineNum); err != nil {
+			return chr, err
+		}
+	}
+	return chr, nil
+}
+
+// outputFor returns the output of a chromosome value: the value itself in
+// dynamic mode, else the matching target name or UnknownChr
+func (cp *ChromosomeProcessor) outputFor(chr string) string {
+	if cp.opts.Dynamic {
+		return cp.dynamicOutputName(chr)
+	}
+	if cp.chrSet[chr] {
+		return chr
+	}
+	if canonical, ok := cp.aliases[aliasKey(chr, cp.opts)]; ok {
+		return canonical
+	}
+	return cp.unknownChr
+}
+
+// writeLine appends one line to the output of the specified chromosome
+func (cp *ChromosomeProcessor) writeLine(chr string, line []byte) error {
+	cp.progressLines.Add(1)
+	switch chr {
+	case commentChr, headerChr, filteredChr, regionChr, fastaStartChr:
+		return nil
+	case FastaChr:
+		return cp.writeFASTA(line)
+	}
+	if chr == OversizeChr && (cp.opts.DryRun || cp.opts.OversizePolicy != OversizeRoute) {
+		return nil
+	}
+	if chr == MalformedChr {
+		if !cp.opts.Strict {
+			// only validating: the line goes where it always went
+			chr = cp.unknownChr
+		} else if cp.opts.DryRun || !cp.opts.WriteMalformed {
+			return nil
+		}
+	}
+	if chr == cp.unknownChr && cp.opts.DropUnknown {
+		cp.tally.dropped++
+		return nil
+	}
+	if cp.opts.SampleRate > 0 && !cp.keepSampled(chr) {
+		return nil
+	}
+	if cp.opts.Dedup && cp.isDuplicate(chr, line) {
+		return nil
+	}
+	if cp.opts.PerChrSample > 0 {
+		cp.reserveRecord(chr, line)
+		return nil
+	}
+	return cp.routeRecord(chr, line)
+}
+
+// routeRecord counts, or serializes and writes, a record that passed the
+// filters of writeLine
+func (cp *ChromosomeProcessor) routeRecord(chr string, line []byte) error {
+	if cp.opts.StatsField != "" {
+		cp.tallyField(chr, line)
+	}
+	if cp.opts.DryRun {
+		cp.counts[chr]++
+		return nil
+	}
+
+	record := line
+	line = cp.serialize(chr, line)
+	if cp.opts.SortBuffer > 0 {
+		return cp.sortRecord(chr, record, line)
+	}
+	pos, hasPos := int64(0), false
+	if cp.opts.IndexEvery > 0 {
+		pos, hasPos = cp.ExtractPosition(record)
+	}
+	if err := cp.writeRecord(chr, line, pos, hasPos); err != nil {
+		return err
+	}
+	cp.counts[chr]++
+	return nil
+}
+
+// writeRecord writes one serialized record to the current part of the
+// output of chr; pos is the position of the record for the index, if any
+func (cp *ChromosomeProcessor) writeRecord(chr string, line []byte, pos int64, hasPos bool) error {
+	size := len(line)
+	switch {
+	case cp.opts.OutputFormat == FormatJSONSeq:
+		size += 2
+	case !cp.isMsgpack():
+		size++
+	}
+	name, err := cp.currentPart(chr, size)
+	if err != nil {
+		return err
+	}
+	writer, err := cp.outputWriter(name)
+	if err != nil {
+		return err
+	}
+	if cp.opts.IndexEvery > 0 {
+		cp.indexRecord(name, pos, hasPos, size)
+	}
+	cp.routedBytes[name] += int64(size)
+	if cp.opts.OutputFormat == FormatJSONSeq {
+		if err := writer.WriteByte(recordSeparator); err != nil {
+			return fmt.Errorf("failed to write to output file: %v", err)
+		}
+	}
+	if _, err := writer.Write(line); err != nil {
+		return fmt.Errorf("failed to write to output file: %v", err)
+	}
+	if cp.isMsgpack() {
+		// MessagePack records delimit themselves
+		return nil
+	}
+	if err := writer.WriteByte(cp.recordDelim()); err != nil {
+		return fmt.Errorf("failed to write newline: %v", err)
+	}
+	return nil
+}
+
+// recordDelim returns the byte written after every output record
+func (cp *ChromosomeProcessor) recordDelim() byte {
+	if cp.opts.RecordDelimiter != "" {
+		return cp.opts.RecordDelimiter[0]
+	}
+	return '\n'
+}
+
+// ProcessFile processes the input files in order
+func (cp *ChromosomeProcessor) ProcessFile() error {
+	cp.reset()
+	if cp.opts.MergeSorted {
+		if err := cp.checkMergeInputs(); err != nil {
+			return err
+		}
+	}
+	if cp.opts.Checkpoint != "" {
+		if err := cp.checkCheckpointInputs(); err != nil {
+			return err
+		}
+		if cp.opts.Resume {
+			if err := cp.loadCheckpoint(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cp.opts.FilenameTemplate != "" && !cp.opts.DryRun {
+		if err := cp.checkOutputNames(); err != nil {
+			return err
+		}
+	}
+	if !cp.opts.DryRun && !cp.opts.Force && !cp.opts.Append {
+		if err := cp.checkExistingOutputs(); err != nil {
+			return err
+		}
+	}
+	if err := cp.checkRemoteInputs(); err != nil {
+		return err
+	}
+
+	if cp.opts.DryRun {
+		if err := cp.processInputs(); err != nil {
+			return err
+		}
+		if err := cp.writeSamples(); err != nil {
+			return err
+		}
+		return cp.checkInvalidFraction()
+	}
+
+	if err := cp.InitializeOutputFiles(); err != nil {
+		cp.discardOutputs()
+		return err
+	}
+	defer cp.CloseAllFiles()
+
+	// the outputs are flushed and closed even when the run stops early, so
+	// an interrupted run leaves complete lines only
+	defer cp.removeSortRuns()
+	err := cp.processInputs()
+	if err == nil && !cp.hdr.done {
+		// the inputs held no record: the outputs still get the header
+		err = cp.finishHeader()
+	}
+	if cp.opts.PerChrSample > 0 && (err == nil || errors.Is(err, ErrInterrupted)) {
+		// like the sorted records below, the samples of an interrupted run
+		// are written too
+		if sampleErr := cp.writeSamples(); sampleErr != nil {
+			err = sampleErr
+		}
+	}
+	if cp.opts.SortBuffer > 0 && (err == nil || errors.Is(err, ErrInterrupted)) {
+		// the records routed so far are written, sorted, by an interrupted
+		// run too
+		if sortErr := cp.writeSorted(); sortErr != nil {
+			err = sortErr
+		}
+	}
+	if closeErr := cp.CloseAllFiles(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = cp.checkInvalidFraction()
+	}
+
+	// outputs are written under temporary names and only get their final
+	// names when the whole run succeeded
+	switch {
+	case err == nil:
+		if cp.opts.Checkpoint != "" {
+			os.Remove(cp.opts.Checkpoint)
+		}
+		if err := cp.publishOutputs(); err != nil {
+			return err
+		}
+		if cp.opts.IndexEvery > 0 {
+			return cp.writeIndexes()
+		}
+		return nil
+	case errors.Is(err, ErrInterrupted):
+		// the partial outputs are kept under their temporary names, and
+		// with a checkpoint the run can be resumed from where it stopped
+		if cp.opts.Checkpoint != "" {
+			if ckErr := cp.writeCheckpoint(); ckErr != nil {
+				return ckErr
+			}
+		}
+	default:
+		cp.discardOutputs()
+		if cp.opts.Checkpoint != "" {
+			os.Remove(cp.opts.Checkpoint)
+		}
+	}
+	return err
+}
+
+// reset clears the counts and the state of the previous run
+func (cp *ChromosomeProcessor) reset() {
+	cp.counts = make(map[string]int, len(cp.chrNames)+1)
+	if !cp.lazyOutputs() {
+		for _, chr := range cp.chrNames {
+			cp.counts[chr] = 0
+		}
+	}
+	cp.counts[cp.unknownChr] = 0
+	if cp.isSAM() {
+		cp.counts[UnmappedChr] = 0
+	}
+	cp.inputStats = nil
+	cp.malformed = nil
+	cp.tally = runCounts{skipLeft: cp.opts.SkipLines}
+	cp.dedupSets = make(map[string]map[uint64]struct{})
+	cp.fieldStats = make(map[string]map[string]int)
+	cp.dedupSeed = maphash.MakeSeed()
+	cp.hdr = headerState{region: make(map[string][][]byte), written: make(map[string]bool)}
+	cp.parts = make(map[string]int)
+	cp.partBytes = make(map[string]int64)
+	cp.partLines = make(map[string]int)
+	cp.indexes = make(map[string]*outputIndex)
+	cp.routedBytes = make(map[string]int64)
+	cp.sorters = make(map[string]*outputSorter)
+	cp.pathOwners = make(map[string]string)
+	cp.scanned = make(map[string]int)
+	cp.rng = rand.New(rand.NewPCG(uint64(cp.opts.Seed), 0))
+	cp.reservoirs = make(map[string]*reservoir)
+	cp.progressLines.Store(0)
+	cp.progressBytes.Store(0)
+	cp.streamName, cp.streamN = "", 0
+	cp.ckpt = checkpointState{last: time.Now()}
+}
+
+// processInputs reads the input files (and archive members) in order
+func (cp *ChromosomeProcessor) processInputs() error {
+	if cp.opts.MergeSorted && len(cp.inputFiles) > 1 {
+		return cp.processMerged()
+	}
+	for i, path := range cp.inputFiles {
+		if cp.atLineLimit() {
+			break
+		}
+		if cp.ckpt.resumeAt != nil && i < cp.ckpt.resumeAt.Input {
+			// read before the checkpoint
+			continue
+		}
+		cp.ckpt.input = i
+		if isTarInput(path) {
+			if err := cp.processTar(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if isZipInput(path) {
+			if err := cp.processZip(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		stat := InputStat{Input: InputDisplayName(path)}
+		err := cp.processInput(path, &stat)
+		cp.inputStats = append(cp.inputStats, stat)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkInvalidFraction fails a validated run whose fraction of invalid lines
+// is above MaxInvalidFraction. It runs once all inputs are read, from the
+// final counts.
+func (cp *ChromosomeProcessor) checkInvalidFraction() error {
+	if !cp.opts.Validate || cp.tally.malformed == 0 {
+		return nil
+	}
+	total := 0
+	for _, stat := range cp.inputStats {
+		total += stat.Lines
+	}
+	fraction := float64(cp.tally.malformed) / float64(total)
+	if fraction > cp.opts.MaxInvalidFraction {
+		return fmt.Errorf("%d of %d lines (%.2f%%) are not valid JSON, above the limit of %.2f%%",
+			cp.tally.malformed, total, 100*fraction, 100*cp.opts.MaxInvalidFraction)
+	}
+	return nil
+}
+
+// checkRemoteInputs verifies that every object store input exists and is
+// readable, so permission and missing-key errors stop the run before any
+// output file is created
+func (cp *ChromosomeProcessor) checkRemoteInputs() error {
+	for _, path := range cp.inputFiles {
+		var err error
+		switch {
+		case isS3Input(path):
+			_, err = statS3(context.Background(), path)
+		case isGCSInput(path):
+			_, err = statGCS(context.Background(), path)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// processInput routes every line of one input file and records the number of
+// lines written and bytes read in stat
+func (cp *ChromosomeProcessor) processInput(path string, stat *InputStat) error {
+	name := InputDisplayName(path)
+
+	input, err := cp.openInput(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	defer input.Close()
+	defer func() {
+		stat.Bytes = input.raw.n
+		cp.logf("Finished %s: %d lines, %d bytes read", name, stat.Lines, stat.Bytes)
+	}()
+	cp.logf("Opened %s", name)
+
+	return cp.scanLines(input, name, stat)
+}
+
+// scanLines routes every line read from r and records the number of lines
+// written in stat; name identifies the input in error messages
+func (cp *ChromosomeProcessor) scanLines(r io.Reader, name string, stat *InputStat) error {
+	var lineNum, routed int
+	var err error
+	defer func() {
+		stat.Lines = routed
+	}()
+	if cp.atLineLimit() {
+		return nil
+	}
+
+	// a ##FASTA section runs to the end of its input
+	cp.inFASTA = false
+	cp.ckpt.pos = checkpoint{Input: cp.ckpt.input}
+	resumed := cp.ckpt.resumeAt
+	if resumed != nil {
+		lineNum, routed = resumed.Line, resumed.Routed
+		cp.inFASTA = resumed.InFASTA
+		cp.ckpt.pos = checkpoint{Input: resumed.Input, Offset: resumed.Offset, Line: resumed.Line, Routed: resumed.Routed}
+		cp.ckpt.resumeAt = nil
+	}
+
+	scanner := cp.newScanner(r)
+
+	// the header row of TSV or CSV input names the columns of this input;
+	// it is read before any worker routes a record
+	// (a resumed input has it read already)
+	if cp.isDelimited() && cp.opts.ChrColumn != "" && (resumed == nil || resumed.Offset == 0) && scanner.Scan() {
+		lineNum++
+		line := scanner.Bytes()
+		if err := cp.readHeaderRow(line, name); err != nil {
+			return err
+		}
+		if _, err := cp.checkLine(headerChr, line, name, lineNum); err != nil {
+			return err
+		}
+		if err := cp.writeLine(headerChr, line); err != nil {
+			return err
+		}
+		routed++
+		if cp.opts.Checkpoint != "" {
+			if err := cp.checkpointPos(scanner, lineNum, routed); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cp.opts.Workers > 1 && !cp.opts.Follow && cp.opts.Checkpoint == "" {
+		var parallelRouted int
+		lineNum, parallelRouted, err = cp.processParallel(scanner, name, lineNum)
+		routed += parallelRouted
+		if err != nil || cp.atLineLimit() {
+			return err
+		}
+	} else {
+		for scanner.Scan() {
+			if cp.interrupted() {
+				return ErrInterrupted
+			}
+			lineNum++
+			line := scanner.Bytes()
+			if !cp.skipLine() && len(line) > 0 {
+				chr, err := cp.checkLine(cp.routeLine(line), line, name, lineNum)
+				if err != nil {
+					return err
+				}
+				if err := cp.writeLine(chr, line); err != nil {
+					return fmt.Errorf("%v at %s %s", err, name, cp.recordPos(lineNum))
+				}
+				routed++
+				if cp.routedLine() {
+					break
+				}
+			}
+			if cp.opts.Checkpoint != "" {
+				if err := cp.checkpointPos(scanner, lineNum, routed); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s at %s (%d lines routed before the error): %v", name, cp.recordPos(lineNum+1), routed, err)
+	}
+
+	return nil
+}
+
+// newScanner returns the record scanner of an input
+func (cp *ChromosomeProcessor) newScanner(r io.Reader) recordScanner {
+	if input, ok := r.(*inputReader); ok && input.mapped != nil {
+		// the lines of a mapped input are sliced out of the mapping
+		return input.mapped
+	}
+
+	// a followed file is read as plain UTF-8, peeking for a byte order mark
+	// would hold back a short first line; MessagePack is binary
+	if !cp.opts.Follow && !cp.isMsgpack() {
+		r = decodeText(r, cp.opts.InputEncoding)
+	}
+
+	// lines have no size limit, a record of hundreds of megabytes is
+	// assembled in memory
+	if cp.opts.RecordDelimiter != "" {
+		lr := newLineReader(r)
+		lr.delim = cp.recordDelim()
+		return lr
+	}
+	return newRecordScanner(r, cp.opts.Format)
+}
+
+// logf logs a verbose event to the Logger option, if set
+func (cp *ChromosomeProcessor) logf(format string, args ...any) {
+	if cp.opts.Logger != nil {
+		cp.opts.Logger.Printf(format, args...)
+	}
+}
+
+// recordMalformed counts a line that is not valid JSON and keeps its
+// position for the first maxMalformedReported of them
+func (cp *ChromosomeProcessor) recordMalformed(name string, lineNum int) {
+	cp.tally.malformed++
+	if len(cp.malformed) < maxMalformedReported {
+		cp.malformed = append(cp.malformed, MalformedLine{Input: name, Line: lineNum})
+	}
+}
+
+// Malformed returns the number of lines that were not valid JSON in the last
+// ProcessFile call (in strict mode) and the positions of the first of them
+func (cp *ChromosomeProcessor) Malformed() (int, []MalformedLine) {
+	return cp.tally.malformed, append([]MalformedLine(nil), cp.malformed...)
+}
+
+// Stop ends a running ProcessFile early; it may be called from another
+// goroutine, e.g. a signal handler. Routing stops before the next line and
+// ProcessFile flushes and closes the outputs, so they hold complete lines
+// only, then returns ErrInterrupted. A followed input (see Options.Follow)
+// instead ends as if it had reached its end, and the run completes normally.
+func (cp *ChromosomeProcessor) Stop() {
+	cp.stopOnce.Do(func() {
+		cp.stopped.Store(true)
+		close(cp.stop)
+	})
+}
+
+// interrupted reports whether Stop cut the run short
+func (cp *ChromosomeProcessor) interrupted() bool {
+	return cp.stopped.Load() && !cp.opts.Follow
+}
+
+// Stats returns the number of lines written to each output, keyed by
+// chromosome name. Every target chromosome and UnknownChr has an entry, even
+// when no line was routed to it. The counts are updated while ProcessFile
+// runs, so after it returns they describe the whole run.
+func (cp *ChromosomeProcessor) Stats() map[string]int {
+	stats := make(map[string]int, len(cp.counts))
+	for chr, n := range cp.counts {
+		stats[chr] = n
+	}
+	return stats
+}
+
+// InputStats returns the number of lines routed from each input file, in
+// processing order, as of the last ProcessFile call
+func (cp *ChromosomeProcessor) InputStats() []InputStat {
+	return append([]InputStat(nil), cp.inputStats...)
+}
+
+// FlushAllWriters flushes all output writers
+func (cp *ChromosomeProcessor) FlushAllWriters() error {
+	var firstErr error
+	for chr, writer := range cp.out.writers {
+		cp.logf("Flushing %d buffered bytes of %s", writer.Buffered(), cp.TempPath(chr))
+		if err := writer.Flush(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to flush output for %s: %v", chr, err)
+		}
+	}
+	return firstErr
+}
+
+// CloseAllFiles flushes and closes all open output files (see closeOutput
+// for the order of the layers)
+func (cp *ChromosomeProcessor) CloseAllFiles() error {
+	var firstErr error
+	for chr := range cp.out.files {
+		if err := cp.closeOutput(chr); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,35 @@
+package chrsplit
+
+import "os"
+
+// Progress returns the number of lines routed so far and the number of raw
+// (possibly compressed) input bytes read so far. It may be called from
+// another goroutine while ProcessFile runs.
+func (cp *ChromosomeProcessor) Progress() (lines, bytes int64) {
+	return cp.progressLines.Load(), cp.progressBytes.Load()
+}
+
+// InputSize returns the total size in bytes of the inputs, to be compared
+// with the bytes reported by Progress, or 0 when it is not known up front:
+// for stdin, remote inputs, zip archives (read by member) or a followed file
+func (cp *ChromosomeProcessor) InputSize() int64 {
+	if cp.opts.Follow {
+		return 0
+	}
+	var total int64
+	for _, path := range cp.inputFiles {
+		if path == StdinInput || IsRemoteInput(path) || isZipInput(path) {
+			return 0
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return 0
+		}
+		total += info.Size()
+	}
+	if cp.opts.RangeEnd > 0 {
+		// about the bytes of the range are read
+		return min(cp.opts.RangeEnd, total) - min(cp.opts.RangeStart, total)
+	}
+	return total
+}
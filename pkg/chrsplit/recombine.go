@@ -0,0 +1,308 @@
+package chrsplit
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// recombineFile is one split output read back by Recombine
+type recombineFile struct {
+	path  string
+	chr   string
+	shard int
+	part  int
+}
+
+// recombineSource is an open recombineFile of a position merge, holding
+// its next record
+type recombineSource struct {
+	index int
+	input *inputReader
+	lines *lineReader
+	line  []byte
+	pos   int64
+}
+
+// recombineHeap is a min-heap of the sources by the position of their next
+// record; ties go to the earlier file
+type recombineHeap []*recombineSource
+
+func (h recombineHeap) Len() int { return len(h) }
+func (h recombineHeap) Less(i, j int) bool {
+	if h[i].pos != h[j].pos {
+		return h[i].pos < h[j].pos
+	}
+	return h[i].index < h[j].index
+}
+func (h recombineHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *recombineHeap) Push(x any)   { *h = append(*h, x.(*recombineSource)) }
+func (h *recombineHeap) Pop() any {
+	old := *h
+	src := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return src
+}
+
+// shardSuffix matches the suffix Shard gives the output names
+var shardSuffix = regexp.MustCompile(`\.shard(\d+)$`)
+
+// recombineFile returns the output a split file was written for, from its
+// name (prefix_chr1.jsonl.gz, prefix_chr1.part0002.shard003.jsonl) or, for
+// the subdirs layout, its directory (chr=chr1/data.jsonl), with its shard
+// and part numbers
+func (cp *ChromosomeProcessor) recombineFile(path string) (recombineFile, error) {
+	name := filepath.Base(InputDisplayName(path))
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".bgz", ".zst", ".bz2", ".xz":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	f := recombineFile{path: path, part: 1}
+	if m := shardSuffix.FindStringSubmatchIndex(name); m != nil {
+		f.shard, _ = strconv.Atoi(name[m[2]:m[3]])
+		name = name[:m[0]]
+	}
+
+	var chr string
+	if dir := filepath.Base(filepath.Dir(path)); strings.HasPrefix(dir, "chr=") {
+		chr = strings.TrimPrefix(dir, "chr=")
+	} else if prefix := filepath.Base(cp.prefix) + "_"; strings.HasPrefix(name, prefix) {
+		chr = strings.TrimPrefix(name, prefix)
+	} else {
+		return f, fmt.Errorf("%s is not a split output of prefix %s", path, cp.prefix)
+	}
+	if m := partSuffix.FindStringSubmatchIndex(chr); m != nil {
+		f.part, _ = strconv.Atoi(chr[m[2]:m[3]])
+		chr = chr[:m[0]]
+	}
+	f.chr = chr
+	return f, nil
+}
+
+// recombineRank orders the outputs of Recombine: chromosomes first, then
+// the unknown and unmapped records, then the records set aside
+func (cp *ChromosomeProcessor) recombineRank(chr string) int {
+	switch chr {
+	case cp.unknownChr, UnmappedChr:
+		return 1
+	case MalformedChr, OversizeChr:
+		return 2
+	}
+	return 0
+}
+
+// Recombine is the inverse of a split: it reads the split outputs given as
+// inputs back and writes their records to path (stdout for "-"), the
+// outputs in natural chromosome order and the shards and parts of an
+// output in order.
+// With byPosition, the files of the same output are merged by PosFieldName
+// instead of concatenated, which keeps the records sorted when the
+// outputs were split with SortBuffer. Inputs are decompressed as usual and
+// the result is compressed with the output compression. Like the outputs of
+// a split, path is written under a temporary name and renamed when complete.
+func (cp *ChromosomeProcessor) Recombine(path string, byPosition bool) (int, error) {
+	tmp := path + ".tmp"
+	files := make([]recombineFile, 0, len(cp.inputFiles))
+	for _, input := range cp.inputFiles {
+		if path != "-" && (samePath(input, path) || samePath(input, tmp)) {
+			return 0, fmt.Errorf("%s is both an input and the output", input)
+		}
+		f, err := cp.recombineFile(input)
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, f)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		switch {
+		case cp.recombineRank(a.chr) != cp.recombineRank(b.chr):
+			return cp.recombineRank(a.chr) < cp.recombineRank(b.chr)
+		case a.chr != b.chr:
+			return naturalChrLess(a.chr, b.chr)
+		case a.shard != b.shard:
+			return a.shard < b.shard
+		}
+		return a.part < b.part
+	})
+
+	out := io.WriteCloser(os.Stdout)
+	if path != "-" {
+		file, err := os.Create(tmp)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create %s: %v", tmp, err)
+		}
+		out = file
+	}
+	zw, err := cp.newCompressWriter(out)
+	if err != nil {
+		if path != "-" {
+			out.Close()
+			os.Remove(tmp)
+		}
+		return 0, fmt.Errorf("failed to create compressor: %v", err)
+	}
+	w := bufio.NewWriterSize(out, outputBufferSize)
+	if zw != nil {
+		w = bufio.NewWriterSize(zw, outputBufferSize)
+	}
+
+	records := 0
+	for start := 0; start < len(files) && err == nil; {
+		end := start + 1
+		for end < len(files) && files[end].chr == files[start].chr {
+			end++
+		}
+		var n int
+		if byPosition {
+			n, err = cp.mergeByPosition(w, files[start:end])
+		} else {
+			n, err = cp.concatenate(w, files[start:end])
+		}
+		records += n
+		start = end
+	}
+	if flushErr := w.Flush(); err == nil && flushErr != nil {
+		err = fmt.Errorf("failed to write %s: %v", path, flushErr)
+	}
+	if zw != nil {
+		if closeErr := zw.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write %s: %v", path, closeErr)
+		}
+	}
+	if path != "-" {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write %s: %v", path, closeErr)
+		}
+		if err == nil {
+			if renameErr := os.Rename(tmp, path); renameErr != nil {
+				err = fmt.Errorf("failed to finish %s: %v", path, renameErr)
+			}
+		}
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}
+	return records, err
+}
+
+// samePath reports whether paths a and b name the same file, comparing the
+// files themselves when both exist, so links and other spellings of a path
+// are caught too
+func samePath(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// concatenate copies the records of files to w, one file after the other
+func (cp *ChromosomeProcessor) concatenate(w io.Writer, files []recombineFile) (int, error) {
+	records := 0
+	for _, f := range files {
+		input, err := cp.openInput(f.path)
+		if err != nil {
+			return records, fmt.Errorf("failed to open %s: %v", f.path, err)
+		}
+		lines := newLineReader(input)
+		for lines.Scan() {
+			if err := writeRecombined(w, lines.Bytes()); err != nil {
+				input.Close()
+				return records, err
+			}
+			records++
+		}
+		input.Close()
+		if err := lines.Err(); err != nil {
+			return records, fmt.Errorf("failed to read %s: %v", f.path, err)
+		}
+	}
+	return records, nil
+}
+
+// mergeByPosition writes the records of files to w in PosFieldName order,
+// records without a position last
+func (cp *ChromosomeProcessor) mergeByPosition(w io.Writer, files []recombineFile) (int, error) {
+	h := make(recombineHeap, 0, len(files))
+	defer func() {
+		for _, src := range h {
+			src.input.Close()
+		}
+	}()
+	for i, f := range files {
+		input, err := cp.openInput(f.path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open %s: %v", f.path, err)
+		}
+		src := &recombineSource{index: i, input: input, lines: newLineReader(input)}
+		ok, err := cp.advanceRecombined(src, f.path)
+		if !ok {
+			input.Close()
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		h = append(h, src)
+	}
+	heap.Init(&h)
+
+	records := 0
+	for h.Len() > 0 {
+		src := h[0]
+		if err := writeRecombined(w, src.line); err != nil {
+			return records, err
+		}
+		records++
+		ok, err := cp.advanceRecombined(src, files[src.index].path)
+		if err != nil {
+			return records, err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			src.input.Close()
+			heap.Pop(&h)
+		}
+	}
+	return records, nil
+}
+
+// advanceRecombined reads the next record of a source, returning false at
+// the end of its file
+func (cp *ChromosomeProcessor) advanceRecombined(src *recombineSource, path string) (bool, error) {
+	if !src.lines.Scan() {
+		if err := src.lines.Err(); err != nil {
+			return false, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		return false, nil
+	}
+	src.line = append(src.line[:0], src.lines.Bytes()...)
+	pos, ok := cp.ExtractPosition(src.line)
+	if !ok {
+		pos = noPosition
+	}
+	src.pos = pos
+	return true, nil
+}
+
+// writeRecombined writes one record and its newline
+func writeRecombined(w io.Writer, line []byte) error {
+	if _, err := w.Write(line); err != nil {
+		return fmt.Errorf("failed to write record: %v", err)
+	}
+	if _, err := w.Write([]byte{'\n'}); err != nil {
+		return fmt.Errorf("failed to write record: %v", err)
+	}
+	return nil
+}
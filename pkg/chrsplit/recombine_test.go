@@ -0,0 +1,80 @@
+package chrsplit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// splitForRecombine splits records and returns the paths of the outputs
+func splitForRecombine(t *testing.T, records []string) []string {
+	t.Helper()
+	cp := runSplit(t, []string{writeInput(t, "in.jsonl", records, "\n")}, Options{})
+	outputs, err := filepath.Glob(filepath.Join(cp.opts.OutputDir, "out_*.jsonl"))
+	if err != nil || len(outputs) == 0 {
+		t.Fatalf("no split outputs: %v", err)
+	}
+	return outputs
+}
+
+func TestRecombine(t *testing.T) {
+	records := testRecords(1000)
+	path := filepath.Join(t.TempDir(), "merged.jsonl")
+	cp := NewChromosomeProcessor(splitForRecombine(t, records), "out", "chr", nil, Options{})
+	n, err := cp.Recombine(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); n != len(records) || !sameRecords(got, records) {
+		t.Errorf("merged %d records (%d in the file), want the %d split records", n, len(got), len(records))
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file is left behind: %v", err)
+	}
+}
+
+func TestRecombineFailureKeepsOutput(t *testing.T) {
+	inputs := splitForRecombine(t, testRecords(1000))
+	// an input that is not a split output fails the merge half way
+	inputs = append(inputs, filepath.Join(filepath.Dir(inputs[0]), "out_chrY.jsonl"))
+	path := filepath.Join(t.TempDir(), "merged.jsonl")
+	if err := os.WriteFile(path, []byte("previous\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cp := NewChromosomeProcessor(inputs, "out", "chr", nil, Options{})
+	if _, err := cp.Recombine(path, false); err == nil {
+		t.Fatal("merging a missing file succeeded")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "previous\n" {
+		t.Errorf("the existing output changed: %q, %v", data, err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file is left behind: %v", err)
+	}
+}
+
+func TestRecombineOutputIsInput(t *testing.T) {
+	inputs := splitForRecombine(t, testRecords(1000))
+	before, err := os.ReadFile(inputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(t.TempDir(), "merged.jsonl")
+	if err := os.Symlink(inputs[0], link); err != nil {
+		t.Skipf("no symlinks: %v", err)
+	}
+	for _, path := range []string{inputs[0], filepath.Join(filepath.Dir(inputs[0]), ".", filepath.Base(inputs[0])), link} {
+		cp := NewChromosomeProcessor(inputs, "out", "chr", nil, Options{})
+		if _, err := cp.Recombine(path, false); err == nil || !strings.Contains(err.Error(), "both an input and the output") {
+			t.Errorf("Recombine to %s: got %v, want an input and output error", path, err)
+		}
+	}
+	if after, err := os.ReadFile(inputs[0]); err != nil || string(after) != string(before) {
+		t.Errorf("the input changed: %v", err)
+	}
+}
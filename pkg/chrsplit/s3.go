@@ -0,0 +1,139 @@
+package chrsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// s3ChunkSize is the size of each ranged GET issued for an S3 input
+	s3ChunkSize = 8 * 1024 * 1024
+	// s3Retries is the number of times a failed ranged GET is retried
+	s3Retries = 3
+)
+
+// isS3Input reports whether the input is an s3://bucket/key URL
+func isS3Input(path string) bool {
+	return strings.HasPrefix(path, "s3://")
+}
+
+// parseS3URL splits an s3://bucket/key URL
+func parseS3URL(path string) (string, string, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(path, "s3://"), "/")
+	if !ok || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 URL %q (expected s3://bucket/key)", path)
+	}
+	return bucket, key, nil
+}
+
+// s3Object is an S3 object whose existence and size have been checked
+type s3Object struct {
+	client *s3.Client
+	bucket string
+	key    string
+	size   int64
+}
+
+// statS3 resolves credentials through the standard AWS chain (environment,
+// shared config, SSO, instance and container roles) and checks that the
+// object is readable, so AccessDenied or NoSuchKey surface before any output
+// file is created
+func statS3(ctx context.Context, path string) (*s3Object, error) {
+	bucket, key, err := parseS3URL(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+	client := s3.NewFromConfig(cfg)
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{client: client, bucket: bucket, key: key, size: aws.ToInt64(head.ContentLength)}, nil
+}
+
+// s3RangeReader reads an object sequentially with ranged GETs of
+// s3ChunkSize bytes, retrying a failed range from the last byte delivered
+type s3RangeReader struct {
+	ctx    context.Context
+	obj    *s3Object
+	offset int64
+	end    int64
+	body   io.ReadCloser
+}
+
+// openS3 streams an S3 object. The ranged reads run on their own goroutine a
+// few megabytes ahead of the scanner, so network latency does not stall it.
+func openS3(path string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	obj, err := statS3(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return newAsyncReader(&s3RangeReader{ctx: ctx, obj: obj}), nil
+}
+
+func (r *s3RangeReader) openRange() error {
+	r.end = min(r.offset+s3ChunkSize, r.obj.size)
+	out, err := r.obj.client.GetObject(r.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.obj.bucket),
+		Key:    aws.String(r.obj.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", r.offset, r.end-1)),
+	})
+	if err != nil {
+		return err
+	}
+	r.body = out.Body
+	return nil
+}
+
+func (r *s3RangeReader) Read(p []byte) (int, error) {
+	for failures := 0; ; {
+		if r.offset >= r.obj.size {
+			return 0, io.EOF
+		}
+
+		if r.body == nil {
+			if err := r.openRange(); err != nil {
+				if failures >= s3Retries {
+					return 0, fmt.Errorf("failed to read s3://%s/%s at byte %d: %v", r.obj.bucket, r.obj.key, r.offset, err)
+				}
+				failures++
+				time.Sleep(time.Duration(failures) * time.Second)
+				continue
+			}
+		}
+
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == io.EOF && r.offset >= r.end {
+			// this range is done, the next Read opens the following one
+			r.body.Close()
+			r.body = nil
+			err = nil
+		}
+		if n > 0 || err == nil {
+			return n, nil
+		}
+
+		r.body.Close()
+		r.body = nil
+		if failures >= s3Retries {
+			return 0, fmt.Errorf("failed to read s3://%s/%s at byte %d: %v", r.obj.bucket, r.obj.key, r.offset, err)
+		}
+		failures++
+		time.Sleep(time.Duration(failures) * time.Second)
+	}
+}
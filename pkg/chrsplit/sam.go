@@ -0,0 +1,42 @@
+package chrsplit
+
+// FormatSAM reads uncompressed SAM text: the @ lines are the header and the
+// alignments are routed on RNAME (column 3), with unmapped reads (RNAME *)
+// going to UnmappedChr. Columns are tab-separated; outputs are named .sam.
+const FormatSAM = "sam"
+
+// UnmappedChr is the output of the SAM alignments without a reference
+// (RNAME *)
+const UnmappedChr = "unmapped"
+
+// samUnmapped is the RNAME of an unmapped read
+const samUnmapped = "*"
+
+// isSAM reports whether the inputs are SAM
+func (cp *ChromosomeProcessor) isSAM() bool {
+	return cp.opts.Format == FormatSAM
+}
+
+// chrColumn returns the column (from 0) holding the chromosome of a text
+// record
+func (cp *ChromosomeProcessor) chrColumn() int {
+	switch {
+	case cp.isSAM():
+		return 2
+	case cp.isDelimited():
+		return cp.chrIndex
+	}
+	return 0
+}
+
+// posColumn returns the column (from 0) holding the position of a text
+// record, or -1 for a TSV or CSV input without a PosFieldName column
+func (cp *ChromosomeProcessor) posColumn() int {
+	switch cp.opts.Format {
+	case FormatGFF, FormatSAM:
+		return 3
+	case FormatTSV, FormatCSV:
+		return cp.posIndex
+	}
+	return 1
+}
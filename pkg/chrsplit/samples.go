@@ -0,0 +1,48 @@
+package chrsplit
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// samplePreviewBytes is how much of an unknown record a sample shows
+const samplePreviewBytes = 200
+
+// sampleUnknown logs one of the first SampleUnknown records routed to
+// UnknownChr, with the chromosome value extracted from it
+func (cp *ChromosomeProcessor) sampleUnknown(line []byte, name string, lineNum int) {
+	if cp.opts.SampleLogger == nil || cp.tally.unknownSampled >= cp.opts.SampleUnknown {
+		return
+	}
+	cp.tally.unknownSampled++
+	preview := strconv.Quote(string(line[:min(len(line), samplePreviewBytes)]))
+	if len(line) > samplePreviewBytes {
+		preview += "..."
+	}
+	cp.opts.SampleLogger.Printf("%s at %s %s: %s", cp.unknownReason(line), name, cp.recordPos(lineNum), preview)
+}
+
+// unknownReason tells why a record was routed to UnknownChr: the chromosome
+// value it has, or the lack of one
+func (cp *ChromosomeProcessor) unknownReason(line []byte) string {
+	if chr, found := cp.ExtractChromosome(line); found {
+		return "unknown chromosome " + strconv.Quote(chr)
+	}
+	return "no chromosome field"
+}
+
+// checkUnknown fails the run on a record routed to UnknownChr with the
+// NoUnknown option
+func (cp *ChromosomeProcessor) checkUnknown(line []byte, name string, lineNum int) error {
+	if !cp.opts.NoUnknown {
+		return nil
+	}
+	return fmt.Errorf("%s at %s %s (--no-unknown)", cp.unknownReason(line), name, cp.recordPos(lineNum))
+}
+
+// DroppedUnknown returns the number of records that would have gone to
+// UnknownChr and were dropped with the DropUnknown option in the last
+// ProcessFile call
+func (cp *ChromosomeProcessor) DroppedUnknown() int {
+	return cp.tally.dropped
+}
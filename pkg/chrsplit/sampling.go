@@ -0,0 +1,77 @@
+package chrsplit
+
+import (
+	"bytes"
+	"sort"
+)
+
+// keepSampled reports whether a record of chr is kept by SampleRate,
+// counting it as scanned either way. The records are drawn in input order
+// from a generator seeded with Seed, so a run with the same seed keeps the
+// same records whatever the number of workers.
+func (cp *ChromosomeProcessor) keepSampled(chr string) bool {
+	cp.scanned[chr]++
+	return cp.rng.Float64() < cp.opts.SampleRate
+}
+
+// reservoir holds the records of one output kept by PerChrSample, with
+// their numbers among the records of the output, to restore input order
+type reservoir struct {
+	records [][]byte
+	seqs    []int
+}
+
+// reserveRecord offers a record of chr to its reservoir: the first
+// PerChrSample records are kept, and the n-th after them replaces a random
+// kept record with probability PerChrSample/n, so every record of the
+// output is equally likely to be kept in the end
+func (cp *ChromosomeProcessor) reserveRecord(chr string, line []byte) {
+	cp.scanned[chr]++
+	n := cp.scanned[chr]
+	r, ok := cp.reservoirs[chr]
+	if !ok {
+		r = &reservoir{}
+		cp.reservoirs[chr] = r
+	}
+	if len(r.records) < cp.opts.PerChrSample {
+		r.records = append(r.records, bytes.Clone(line))
+		r.seqs = append(r.seqs, n)
+		return
+	}
+	if i := cp.rng.IntN(n); i < cp.opts.PerChrSample {
+		r.records[i] = append(r.records[i][:0], line...)
+		r.seqs[i] = n
+	}
+}
+
+// writeSamples writes the records kept by PerChrSample to their outputs,
+// in input order
+func (cp *ChromosomeProcessor) writeSamples() error {
+	chrs := make([]string, 0, len(cp.reservoirs))
+	for chr := range cp.reservoirs {
+		chrs = append(chrs, chr)
+	}
+	sort.Strings(chrs)
+	for _, chr := range chrs {
+		r := cp.reservoirs[chr]
+		order := make([]int, len(r.records))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool { return r.seqs[order[a]] < r.seqs[order[b]] })
+		for _, i := range order {
+			if err := cp.routeRecord(chr, r.records[i]); err != nil {
+				return err
+			}
+		}
+		delete(cp.reservoirs, chr)
+	}
+	return nil
+}
+
+// Scanned returns the number of records of every output seen in the last
+// ProcessFile call with SampleRate or PerChrSample, before sampling; Stats
+// counts the records kept
+func (cp *ChromosomeProcessor) Scanned() map[string]int {
+	return cp.scanned
+}
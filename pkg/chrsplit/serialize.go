@@ -0,0 +1,99 @@
+package chrsplit
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// FormatTSV writes the Fields of every record as one tab-separated row
+const FormatTSV = "tsv"
+
+// tsvEscaper keeps a value on one row within its column
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// serialize returns a record as it is written to its output: the line
+// itself, its projection on KeepFields, or its TSV row. Malformed and
+// oversize records are written as they are.
+func (cp *ChromosomeProcessor) serialize(chr string, line []byte) []byte {
+	if chr == MalformedChr || chr == OversizeChr {
+		return line
+	}
+	if cp.opts.OutputFormat == FormatTSV {
+		return cp.tsvRow(line)
+	}
+	if len(cp.keepFields) > 0 {
+		return cp.project(line)
+	}
+	return line
+}
+
+// project returns an object holding only the kept fields of a record, each
+// under its path as the key ("info.gene" for a nested field). The values are
+// copied as their raw JSON, so numbers stay numbers; missing fields are
+// left out.
+func (cp *ChromosomeProcessor) project(line []byte) []byte {
+	obj := append(cp.rowBuf[:0], '{')
+	for i, result := range gjson.GetManyBytes(line, cp.keepFields...) {
+		if !result.Exists() {
+			continue
+		}
+		if len(obj) > 1 {
+			obj = append(obj, ',')
+		}
+		obj = append(obj, cp.keepKeys[i]...)
+		obj = append(obj, ':')
+		obj = append(obj, result.Raw...)
+	}
+	obj = append(obj, '}')
+	cp.rowBuf = obj
+	return obj
+}
+
+// keptFields returns the paths kept by the KeepFields option, with the
+// chromosome field added when missing, and their keys as JSON strings
+func keptFields(chrFieldName string, keep []string) ([]string, [][]byte) {
+	if len(keep) == 0 {
+		return nil, nil
+	}
+	fields := []string{chrFieldName}
+	for _, field := range keep {
+		if field != chrFieldName {
+			fields = append(fields, field)
+		}
+	}
+
+	keys := make([][]byte, len(fields))
+	for i, field := range fields {
+		// a key is the path without gjson escapes, e.g. a\.b -> a.b
+		var key strings.Builder
+		for j := 0; j < len(field); j++ {
+			if field[j] == '\\' && j+1 < len(field) {
+				j++
+			}
+			key.WriteByte(field[j])
+		}
+		keys[i], _ = json.Marshal(key.String())
+	}
+	return fields, keys
+}
+
+// tsvRow returns the Fields of a record as one tab-separated row
+func (cp *ChromosomeProcessor) tsvRow(line []byte) []byte {
+	row := cp.rowBuf[:0]
+	for i, result := range gjson.GetManyBytes(line, cp.opts.Fields...) {
+		if i > 0 {
+			row = append(row, '\t')
+		}
+		switch {
+		case !result.Exists() || result.Type == gjson.Null:
+		case result.IsObject() || result.IsArray():
+			row = append(row, tsvEscaper.Replace(result.Raw)...)
+		default:
+			row = append(row, tsvEscaper.Replace(result.String())...)
+		}
+	}
+	cp.rowBuf = row
+	return row
+}
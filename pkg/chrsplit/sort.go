@@ -0,0 +1,246 @@
+package chrsplit
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// DefaultSortBuffer is the default --sort-buffer, per output
+const DefaultSortBuffer = 64 << 20
+
+// noPosition is the sort key of records without a position, which go after
+// all others
+const noPosition = math.MaxInt64
+
+// outputSorter holds the records of one output until the end of the run:
+// the latest ones in memory, and the earlier ones in sorted runs spilled to
+// temporary files whenever the memory buffer reached SortBuffer bytes
+type outputSorter struct {
+	arena   []byte
+	records []sortEntry
+	runs    []string
+}
+
+// sortEntry is a record in an outputSorter's arena with its position
+type sortEntry struct {
+	pos        int64
+	start, end int
+}
+
+// sortRecord adds a serialized record of chr to its sorter, spilling the
+// buffered records to a run when the buffer is full; record is the input
+// record the position is read from
+func (cp *ChromosomeProcessor) sortRecord(chr string, record, line []byte) error {
+	s, ok := cp.sorters[chr]
+	if !ok {
+		s = &outputSorter{}
+		cp.sorters[chr] = s
+	}
+	pos, ok := cp.ExtractPosition(record)
+	if !ok {
+		pos = noPosition
+	}
+	start := len(s.arena)
+	s.arena = append(s.arena, line...)
+	s.records = append(s.records, sortEntry{pos: pos, start: start, end: len(s.arena)})
+	cp.counts[chr]++
+	if int64(len(s.arena)) >= cp.opts.SortBuffer {
+		return cp.spillRun(chr, s)
+	}
+	return nil
+}
+
+// sortBuffered sorts the buffered records of a sorter by position, keeping
+// the input order of equal positions
+func (s *outputSorter) sortBuffered() {
+	slices.SortStableFunc(s.records, func(a, b sortEntry) int {
+		switch {
+		case a.pos < b.pos:
+			return -1
+		case a.pos > b.pos:
+			return 1
+		}
+		return 0
+	})
+}
+
+// spillRun writes the buffered records of chr, sorted, to a run file in a
+// temporary directory next to the outputs, and empties the buffer
+func (cp *ChromosomeProcessor) spillRun(chr string, s *outputSorter) error {
+	if cp.sortDir == "" {
+		dir, err := os.MkdirTemp(filepath.Join(cp.opts.OutputDir, "."), ".chrsplit-sort-")
+		if err != nil {
+			return fmt.Errorf("failed to create the sort directory: %v", err)
+		}
+		cp.sortDir = dir
+	}
+	file, err := os.CreateTemp(cp.sortDir, "run-")
+	if err != nil {
+		return fmt.Errorf("failed to create a sort run: %v", err)
+	}
+	cp.logf("Spilling %d records (%d bytes) of %s to %s", len(s.records), len(s.arena), chr, file.Name())
+
+	s.sortBuffered()
+	w := bufio.NewWriterSize(file, outputBufferSize)
+	var head []byte
+	for _, r := range s.records {
+		head = binary.AppendVarint(head[:0], r.pos)
+		head = binary.AppendUvarint(head, uint64(r.end-r.start))
+		w.Write(head)
+		w.Write(s.arena[r.start:r.end])
+	}
+	err = w.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write sort run %s: %v", file.Name(), err)
+	}
+	s.runs = append(s.runs, file.Name())
+	s.arena, s.records = s.arena[:0], s.records[:0]
+	return nil
+}
+
+// sortSource is one sorted sequence of records merged by writeSorted: a
+// run file, or the records still in memory
+type sortSource struct {
+	index int
+	r     *bufio.Reader
+	mem   *outputSorter
+	next  int
+	pos   int64
+	line  []byte
+}
+
+// advance reads the next record of the source, reporting false at its end
+func (src *sortSource) advance() (bool, error) {
+	if src.mem != nil {
+		if src.next >= len(src.mem.records) {
+			return false, nil
+		}
+		r := src.mem.records[src.next]
+		src.next++
+		src.pos, src.line = r.pos, src.mem.arena[r.start:r.end]
+		return true, nil
+	}
+	pos, err := binary.ReadVarint(src.r)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	size, err := binary.ReadUvarint(src.r)
+	if err != nil {
+		return false, err
+	}
+	src.line = slices.Grow(src.line[:0], int(size))[:size]
+	if _, err := io.ReadFull(src.r, src.line); err != nil {
+		return false, err
+	}
+	src.pos = pos
+	return true, nil
+}
+
+// sortHeap is a min-heap of sort sources by position; ties go to the
+// earlier source, which holds the earlier records
+type sortHeap []*sortSource
+
+func (h sortHeap) Len() int { return len(h) }
+func (h sortHeap) Less(i, j int) bool {
+	if h[i].pos != h[j].pos {
+		return h[i].pos < h[j].pos
+	}
+	return h[i].index < h[j].index
+}
+func (h sortHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *sortHeap) Push(x any)   { *h = append(*h, x.(*sortSource)) }
+func (h *sortHeap) Pop() any {
+	old := *h
+	src := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return src
+}
+
+// writeSorted writes the records held back by SortBuffer to their outputs,
+// merging the spilled runs of each output with the records in memory
+func (cp *ChromosomeProcessor) writeSorted() error {
+	chrs := make([]string, 0, len(cp.sorters))
+	for chr := range cp.sorters {
+		chrs = append(chrs, chr)
+	}
+	sort.Strings(chrs)
+	for _, chr := range chrs {
+		if err := cp.writeSortedOutput(chr, cp.sorters[chr]); err != nil {
+			return err
+		}
+		delete(cp.sorters, chr)
+	}
+	return nil
+}
+
+// writeSortedOutput merges the runs and the buffered records of one output
+// into it
+func (cp *ChromosomeProcessor) writeSortedOutput(chr string, s *outputSorter) error {
+	s.sortBuffered()
+	h := make(sortHeap, 0, len(s.runs)+1)
+	for i, run := range s.runs {
+		file, err := os.Open(run)
+		if err != nil {
+			return fmt.Errorf("failed to read sort run: %v", err)
+		}
+		defer file.Close()
+		h = append(h, &sortSource{index: i, r: bufio.NewReaderSize(file, lineBufferSize)})
+	}
+	h = append(h, &sortSource{index: len(s.runs), mem: s})
+
+	live := h[:0]
+	for _, src := range h {
+		ok, err := src.advance()
+		if err != nil {
+			return fmt.Errorf("failed to read sort run of %s: %v", chr, err)
+		}
+		if ok {
+			live = append(live, src)
+		}
+	}
+	h = live
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		src := h[0]
+		if err := cp.writeRecord(chr, src.line, src.pos, src.pos != noPosition); err != nil {
+			return err
+		}
+		ok, err := src.advance()
+		if err != nil {
+			return fmt.Errorf("failed to read sort run of %s: %v", chr, err)
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	for _, run := range s.runs {
+		os.Remove(run)
+	}
+	return nil
+}
+
+// removeSortRuns removes the temporary directory of the spilled runs
+func (cp *ChromosomeProcessor) removeSortRuns() {
+	if cp.sortDir != "" {
+		os.RemoveAll(cp.sortDir)
+		cp.sortDir = ""
+	}
+}
@@ -0,0 +1,103 @@
+package chrsplit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// MissingStatsValue is the StatsField value tallied for records without
+// the field
+const MissingStatsValue = "(missing)"
+
+// tallyField counts the StatsField value of a record routed to chr
+func (cp *ChromosomeProcessor) tallyField(chr string, record []byte) {
+	value := MissingStatsValue
+	if result := gjson.GetBytes(record, cp.opts.StatsField); result.Exists() {
+		// tabs and line breaks would break the columns of the report
+		value = strings.Map(func(r rune) rune {
+			if r == '\t' || r == '\n' || r == '\r' {
+				return ' '
+			}
+			return r
+		}, result.String())
+	}
+	tally, ok := cp.fieldStats[chr]
+	if !ok {
+		tally = make(map[string]int)
+		cp.fieldStats[chr] = tally
+	}
+	tally[value]++
+}
+
+// FieldStats returns the number of records of every StatsField value,
+// per output, routed in the last ProcessFile call
+func (cp *ChromosomeProcessor) FieldStats() map[string]map[string]int {
+	return cp.fieldStats
+}
+
+// StatsValues returns the StatsField values seen in the last ProcessFile
+// call, the most frequent first
+func (cp *ChromosomeProcessor) StatsValues() []string {
+	totals := make(map[string]int)
+	for _, tally := range cp.fieldStats {
+		for value, n := range tally {
+			totals[value] += n
+		}
+	}
+	values := make([]string, 0, len(totals))
+	for value := range totals {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if totals[values[i]] != totals[values[j]] {
+			return totals[values[i]] > totals[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	return values
+}
+
+// WriteFieldStats writes the FieldStats cross-tab as TSV: a row per output,
+// in summary order, and a column per StatsValues value
+func (cp *ChromosomeProcessor) WriteFieldStats(w io.Writer) error {
+	values := cp.StatsValues()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "chr\t%s\n", strings.Join(values, "\t"))
+	for _, chr := range cp.SummaryChromosomes() {
+		bw.WriteString(chr)
+		for _, value := range values {
+			fmt.Fprintf(bw, "\t%d", cp.fieldStats[chr][value])
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// WriteFieldStatsFile writes the FieldStats cross-tab to path, or to
+// stdout when path is "-"
+func (cp *ChromosomeProcessor) WriteFieldStatsFile(path string) error {
+	if path == "-" {
+		if err := cp.WriteFieldStats(os.Stdout); err != nil {
+			return fmt.Errorf("failed to write field statistics to stdout: %v", err)
+		}
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create field statistics %s: %v", path, err)
+	}
+	if err := cp.WriteFieldStats(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write field statistics %s: %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write field statistics %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package chrsplit
+
+import (
+	"fmt"
+)
+
+// StartStream prepares the outputs for records handed over one at a time
+// with WriteRecord, e.g. the messages of a Kafka topic, instead of read from
+// the inputs. The outputs are appended to under their final names (see the
+// Append option), so they are usable while the stream runs; SyncAllFiles
+// makes the records written so far durable. name stands for the stream in
+// error messages.
+func (cp *ChromosomeProcessor) StartStream(name string) error {
+	if !cp.opts.Append || cp.opts.DryRun {
+		return fmt.Errorf("a stream needs the Append option and no DryRun")
+	}
+	cp.reset()
+	cp.streamName, cp.streamN = name, 0
+	return cp.InitializeOutputFiles()
+}
+
+// WriteRecord routes and writes one record of a stream started with
+// StartStream
+func (cp *ChromosomeProcessor) WriteRecord(record []byte) error {
+	cp.streamN++
+	if len(record) == 0 {
+		return nil
+	}
+	chr, err := cp.checkLine(cp.routeLine(record), record, cp.streamName, cp.streamN)
+	if err != nil {
+		return err
+	}
+	if err := cp.writeLine(chr, record); err != nil {
+		return fmt.Errorf("%v at %s %s", err, cp.streamName, cp.recordPos(cp.streamN))
+	}
+	return nil
+}
+
+// FinishStream writes the header of a stream that saw no record, then
+// flushes and closes the outputs
+func (cp *ChromosomeProcessor) FinishStream() error {
+	err := cp.finishHeader()
+	if closeErr := cp.CloseAllFiles(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// SyncAllFiles flushes every open output down to the disk: the buffer, the
+// pending gzip or zstd block and the file itself, so the records written so far
+// survive a crash. Outputs closed during a stream, evicted by MaxOpenFiles or
+// finished as a part, were synced when they were closed.
+func (cp *ChromosomeProcessor) SyncAllFiles() error {
+	if err := cp.FlushAllWriters(); err != nil {
+		return err
+	}
+	for chr, file := range cp.out.files {
+		if zw, ok := cp.out.compressors[chr]; ok {
+			if err := zw.Flush(); err != nil {
+				return fmt.Errorf("failed to flush %s output for %s: %v", cp.outputCompression(), chr, err)
+			}
+		}
+		if err := file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync output file for %s: %v", chr, err)
+		}
+	}
+	return nil
+}
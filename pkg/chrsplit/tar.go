@@ -0,0 +1,83 @@
+package chrsplit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// tarSuffixes are the extensions of inputs read as tar archives
+var tarSuffixes = []string{".tar", ".tar.gz", ".tgz", ".tar.zst", ".tar.bz2", ".tar.xz"}
+
+// isTarInput reports whether the input is a (possibly compressed) tar archive
+func isTarInput(path string) bool {
+	for _, suffix := range tarSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// processTar routes the lines of every regular member of a tar archive whose
+// base name matches the member patterns, streaming each member straight from
+// the archive. Every processed member gets its own InputStat, named
+// "archive:member", so an empty or corrupt member stands out.
+func (cp *ChromosomeProcessor) processTar(archivePath string) error {
+	name := InputDisplayName(archivePath)
+	patterns := cp.opts.MemberPatterns
+	if patterns == nil {
+		patterns = DefaultInputPatterns
+	}
+
+	// a .tar.gz (or .tgz, .tar.zst, ...) is decompressed like any input
+	archive, err := cp.openInput(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	defer archive.Close()
+
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive %s: %v", name, err)
+		}
+
+		base := path.Base(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || strings.HasPrefix(base, ".") || !matchesAny(patterns, base) {
+			continue
+		}
+
+		stat := InputStat{Input: name + ":" + hdr.Name}
+		err = cp.processMember(tr, &stat)
+		cp.inputStats = append(cp.inputStats, stat)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// processMember routes the lines of one archive member, which may itself be
+// compressed (e.g. part-0001.jsonl.gz inside a plain tar)
+func (cp *ChromosomeProcessor) processMember(r io.Reader, stat *InputStat) error {
+	raw := &countingReader{r: r}
+	defer func() {
+		stat.Bytes = raw.n
+		cp.logf("Finished %s: %d lines, %d bytes read", stat.Input, stat.Lines, stat.Bytes)
+	}()
+	cp.logf("Opened %s", stat.Input)
+
+	input, err := decompress(raw, stat.Input, CompressionAuto, cp.opts.DecompressThreads)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", stat.Input, err)
+	}
+	defer input.Close()
+
+	return cp.scanLines(input, stat.Input, stat)
+}
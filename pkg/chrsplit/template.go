@@ -0,0 +1,123 @@
+package chrsplit
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// templatePlaceholder matches a {name} placeholder of a FilenameTemplate
+var templatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// partSuffix matches the suffix partKey gives the later parts of an output
+var partSuffix = regexp.MustCompile(`\.part(\d{4,}|\*)$`)
+
+// LayoutSubdirs is the --layout that writes every output to a directory of
+// its own, named hive-style after the chromosome: chr=chr1/data.jsonl
+const LayoutSubdirs = "subdirs"
+
+// SubdirTemplate returns the FilenameTemplate of the LayoutSubdirs layout,
+// with name (by default data{ext}) as the file in every directory
+func SubdirTemplate(name string) string {
+	if name == "" {
+		name = "data{ext}"
+	}
+	return "chr={chr}/" + name
+}
+
+// ValidateFilenameTemplate checks a --filename-template value: it may only
+// use the {prefix}, {chr}, {input}, {part}, {ext} and {shard} placeholders,
+// and names a file below the output directory; '/' separates the
+// directories the outputs are put in
+func ValidateFilenameTemplate(template string) error {
+	for _, placeholder := range templatePlaceholder.FindAllString(template, -1) {
+		switch placeholder {
+		case "{prefix}", "{chr}", "{input}", "{part}", "{ext}", "{shard}":
+		default:
+			return fmt.Errorf("unknown placeholder %s in filename template %q (expected {prefix}, {chr}, {input}, {part}, {ext} or {shard})", placeholder, template)
+		}
+	}
+	literal := templatePlaceholder.ReplaceAllString(template, "")
+	switch {
+	case strings.ContainsAny(literal, "{}"):
+		return fmt.Errorf("unbalanced brace in filename template %q", template)
+	case strings.Contains(literal, `\`):
+		return fmt.Errorf("filename template %q must separate directories with '/'", template)
+	case strings.HasPrefix(template, "/") || strings.HasSuffix(template, "/") || slices.Contains(strings.Split(template, "/"), ".."):
+		return fmt.Errorf("filename template %q must name a file below the output directory", template)
+	case !strings.Contains(template, "{chr}"):
+		return fmt.Errorf("filename template %q needs a {chr} placeholder, so every output gets its own file", template)
+	}
+	return nil
+}
+
+// templatePath renders the FilenameTemplate for an output name: {chr} is the
+// chromosome, with the characters unsafe in file names replaced as in
+// dynamic mode, {part} the part number of an output split into parts (0001
+// for the first), {ext} the usual extension (.jsonl.gz, .vcf, ...) and
+// {shard} the Shard number (003).
+// Without {part} in the template, the later parts keep their .part0002
+// suffix in {chr}.
+func (cp *ChromosomeProcessor) templatePath(name string) string {
+	chr, part, suffix := name, "0001", ""
+	if m := partSuffix.FindStringSubmatchIndex(name); m != nil {
+		chr, part = name[:m[0]], name[m[2]:m[3]]
+		if !strings.Contains(cp.opts.FilenameTemplate, "{part}") {
+			suffix = name[m[0]:]
+		}
+	}
+	if chr != "*" {
+		chr = safeName(chr)
+	}
+	r := strings.NewReplacer("{prefix}", cp.prefix, "{chr}", chr+suffix, "{input}", cp.templateInput(),
+		"{part}", part, "{ext}", cp.outputExt(), "{shard}", fmt.Sprintf("%03d", cp.opts.Shard))
+	return filepath.Join(cp.opts.OutputDir, r.Replace(cp.opts.FilenameTemplate))
+}
+
+// templateInput returns the {input} of the FilenameTemplate: the file name
+// of the first input without its compression and format extensions
+func (cp *ChromosomeProcessor) templateInput() string {
+	if len(cp.inputFiles) == 0 {
+		return ""
+	}
+	name := filepath.Base(InputDisplayName(cp.inputFiles[0]))
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".bgz", ".zst", ".bz2", ".xz":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// claimOutputPath records that an output is written to its path, failing
+// when another output already renders to the same file name. Names are
+// compared ignoring case, which case-insensitive file systems do too.
+func (cp *ChromosomeProcessor) claimOutputPath(name string) error {
+	path := cp.OutputPath(name)
+	key := strings.ToLower(path)
+	if owner, ok := cp.pathOwners[key]; ok && owner != name {
+		return fmt.Errorf("outputs %s and %s both render to %s with filename template %q", strconv.Quote(owner), strconv.Quote(name), path, cp.opts.FilenameTemplate)
+	}
+	cp.pathOwners[key] = name
+	return nil
+}
+
+// checkOutputNames renders the file name of every output known up front
+// and fails, before any file is created, when two of them collide
+func (cp *ChromosomeProcessor) checkOutputNames() error {
+	chrs := cp.OutputChromosomes()
+	if cp.opts.WriteMalformed {
+		chrs = append(chrs, MalformedChr)
+	}
+	if cp.opts.OversizePolicy == OversizeRoute {
+		chrs = append(chrs, OversizeChr)
+	}
+	for _, chr := range chrs {
+		if err := cp.claimOutputPath(chr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package chrsplit
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// text input formats: the chromosome is taken from column 1, header lines
+// are replicated into every output and the records are written untouched
+const (
+	// FormatVCF reads VCF: the meta lines (##) and the #CHROM column header
+	// are the header, columns are tab-separated; outputs are named .vcf
+	FormatVCF = "vcf"
+	// FormatBED reads BED or bedGraph: track, browser and # lines are the
+	// header, columns are separated by tabs or spaces; outputs are named .bed
+	FormatBED = "bed"
+)
+
+// isText reports whether the inputs are in one of the text formats rather
+// than JSON
+func (cp *ChromosomeProcessor) isText() bool {
+	switch cp.opts.Format {
+	case FormatVCF, FormatBED, FormatGFF, FormatSAM, FormatTSV, FormatCSV:
+		return true
+	}
+	return false
+}
+
+// textExt returns the output extension of a text format
+func (cp *ChromosomeProcessor) textExt() string {
+	if cp.isGFF() {
+		return ".gff3"
+	}
+	return "." + cp.opts.Format
+}
+
+// isTextHeader reports whether a line of a text format is a header line
+func (cp *ChromosomeProcessor) isTextHeader(line []byte) bool {
+	switch {
+	case cp.isSAM():
+		return len(line) > 0 && line[0] == '@'
+	case cp.isDelimited():
+		// the header row is taken by position, see readHeaderRow
+		return false
+	}
+	if len(line) > 0 && line[0] == '#' {
+		return true
+	}
+	if cp.opts.Format == FormatBED {
+		for _, keyword := range []string{"track", "browser"} {
+			rest, ok := bytes.CutPrefix(line, []byte(keyword))
+			if ok && (len(rest) == 0 || rest[0] == ' ' || rest[0] == '\t') {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// textColumn returns column i (from 0) of a text record
+func (cp *ChromosomeProcessor) textColumn(line []byte, i int) ([]byte, bool) {
+	switch cp.opts.Format {
+	case FormatBED:
+		fields := bytes.Fields(line)
+		if i >= len(fields) {
+			return nil, false
+		}
+		return fields[i], true
+	case FormatCSV:
+		fields, ok := cp.csvFields(line)
+		if !ok || i >= len(fields) {
+			return nil, false
+		}
+		return []byte(fields[i]), true
+	}
+
+	delim := byte('\t')
+	if cp.isDelimited() {
+		delim = cp.delimiter()
+	}
+	for ; i > 0; i-- {
+		next := bytes.IndexByte(line, delim)
+		if next < 0 {
+			return nil, false
+		}
+		line = line[next+1:]
+	}
+	if next := bytes.IndexByte(line, delim); next >= 0 {
+		line = line[:next]
+	}
+	return line, true
+}
+
+// validTextRecord reports whether a text record is well formed, which is
+// checked like JSON validity with the Strict and Validate options: a VCF
+// record has 8 columns or more and an integer POS, a BED record 3 columns
+// or more and integer start and end, a GFF record 9 columns with integer
+// start and end, a SAM alignment 11 columns or more with integer FLAG, POS
+// and MAPQ, and a TSV or CSV row has a chromosome column (and, for CSV,
+// valid quoting)
+func (cp *ChromosomeProcessor) validTextRecord(line []byte) bool {
+	var minColumns int
+	var intColumns []int
+	switch cp.opts.Format {
+	case FormatVCF:
+		minColumns, intColumns = 8, []int{1}
+	case FormatBED:
+		minColumns, intColumns = 3, []int{1, 2}
+	case FormatGFF:
+		minColumns, intColumns = 9, []int{3, 4}
+	case FormatSAM:
+		minColumns, intColumns = 11, []int{1, 3, 4}
+	case FormatTSV, FormatCSV:
+		minColumns = cp.chrIndex + 1
+	}
+
+	if _, ok := cp.textColumn(line, minColumns-1); !ok {
+		return false
+	}
+	for _, i := range intColumns {
+		col, _ := cp.textColumn(line, i)
+		if _, err := strconv.ParseInt(string(col), 10, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
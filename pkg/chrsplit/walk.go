@@ -0,0 +1,117 @@
+package chrsplit
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultInputPatterns are the file name patterns picked up when walking an
+// input directory
+var DefaultInputPatterns = []string{"*.jsonl", "*.jsonl.gz", "*.jsonl.zst", "*.jsonl.bz2", "*.jsonl.xz"}
+
+// WalkInputDirs replaces every directory among the inputs with the files
+// below it whose name matches one of patterns. Hidden files and directories
+// are skipped, symlinked directories are followed once (so a link loop is not
+// walked forever), and files are listed in lexical path order. It also
+// returns how many files were skipped because they matched no pattern.
+func WalkInputDirs(inputs, patterns []string) ([]string, int, error) {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, 0, fmt.Errorf("invalid file pattern %q: %v", pattern, err)
+		}
+	}
+
+	var files []string
+	skipped := 0
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		if input == StdinInput || err != nil || !info.IsDir() {
+			files = append(files, input)
+			continue
+		}
+
+		w := &dirWalker{patterns: patterns, visited: make(map[string]bool)}
+		if err := w.walk(input); err != nil {
+			return nil, 0, err
+		}
+		files = append(files, w.files...)
+		skipped += w.skipped
+	}
+	return files, skipped, nil
+}
+
+// dirWalker collects matching files below one input directory
+type dirWalker struct {
+	patterns []string
+	visited  map[string]bool
+	files    []string
+	skipped  int
+}
+
+func (w *dirWalker) walk(root string) error {
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", root, err)
+	}
+	if w.visited[real] {
+		return nil
+	}
+	w.visited[real] = true
+
+	// WalkDir visits entries in lexical order and does not follow symlinks,
+	// linked directories are walked explicitly below
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to walk %s: %v", path, err)
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil // dangling link
+			}
+			if info.IsDir() {
+				return w.walk(path)
+			}
+		} else if d.IsDir() {
+			if path != root {
+				real, err := filepath.EvalSymlinks(path)
+				if err == nil && w.visited[real] {
+					return filepath.SkipDir
+				}
+				w.visited[real] = true
+			}
+			return nil
+		}
+
+		if w.matches(d.Name()) {
+			w.files = append(w.files, path)
+		} else {
+			w.skipped++
+		}
+		return nil
+	})
+}
+
+func (w *dirWalker) matches(name string) bool {
+	return matchesAny(w.patterns, name)
+}
+
+// matchesAny reports whether name matches one of the file name patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
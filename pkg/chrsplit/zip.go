@@ -0,0 +1,108 @@
+package chrsplit
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// isZipInput reports whether the input is a zip archive
+func isZipInput(path string) bool {
+	return strings.HasSuffix(path, ".zip")
+}
+
+// zipMember is a member being inflated, possibly ahead of time on its own
+// goroutine
+type zipMember struct {
+	file  *zip.File
+	rc    io.ReadCloser
+	async *asyncReader
+}
+
+func (m *zipMember) reader() io.Reader {
+	if m.async != nil {
+		return m.async
+	}
+	return m.rc
+}
+
+// close releases the member. A member inflated in the background is only
+// closed once it was read to the end, so the goroutine no longer touches it.
+func (m *zipMember) close(finished bool) {
+	if m.async != nil {
+		m.async.Close()
+		if !finished {
+			return
+		}
+	}
+	m.rc.Close()
+}
+
+// processZip routes the lines of every member of a zip archive whose base
+// name matches the member patterns, as one concatenated input in archive
+// order. With several workers, the following members are inflated ahead on
+// their own goroutines while the current one is routed, so the output is the
+// same as with a single worker. A member failing its CRC check stops the run
+// with the member name in the error.
+func (cp *ChromosomeProcessor) processZip(archivePath string) error {
+	name := InputDisplayName(archivePath)
+	if archivePath == StdinInput || IsRemoteInput(archivePath) || !isRegularFile(archivePath) {
+		return fmt.Errorf("cannot read %s: zip inputs must be local regular files (the member index is at the end)", name)
+	}
+	patterns := cp.opts.MemberPatterns
+	if patterns == nil {
+		patterns = DefaultInputPatterns
+	}
+
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	defer zr.Close()
+
+	var members []*zipMember
+	for _, f := range zr.File {
+		base := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(base, ".") || !matchesAny(patterns, base) {
+			continue
+		}
+		members = append(members, &zipMember{file: f})
+	}
+
+	ahead := max(cp.opts.Workers, 1)
+	for i, m := range members {
+		for _, next := range members[i:min(i+ahead, len(members))] {
+			if next.rc != nil {
+				continue
+			}
+			if next.rc, err = next.file.Open(); err != nil {
+				cp.closeZipMembers(members[i:])
+				return fmt.Errorf("failed to open %s:%s: %v", name, next.file.Name, err)
+			}
+			if ahead > 1 {
+				next.async = newAsyncReader(next.rc)
+			}
+		}
+
+		stat := InputStat{Input: name + ":" + m.file.Name}
+		err := cp.processMember(m.reader(), &stat)
+		cp.inputStats = append(cp.inputStats, stat)
+		m.close(err == nil)
+		if err != nil {
+			cp.closeZipMembers(members[i+1:])
+			return err
+		}
+	}
+	return nil
+}
+
+// closeZipMembers releases the members opened ahead when processing stops
+func (cp *ChromosomeProcessor) closeZipMembers(members []*zipMember) {
+	for _, m := range members {
+		if m.rc != nil {
+			m.close(false)
+		}
+	}
+}
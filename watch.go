@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/viktorxia/chrjson-split/pkg/chrsplit"
+)
+
+// subdirectories of the watched directory that completed inputs are moved to
+const (
+	watchDoneDir   = "done"
+	watchFailedDir = "failed"
+)
+
+// watchConfig holds the settings of the watch subcommand
+type watchConfig struct {
+	dir       string
+	patterns  []string
+	chrField  string
+	chrNames  []string
+	outputDir string
+	opts      chrsplit.Options
+}
+
+// runWatch implements `watch`: it polls an ingest directory and splits every
+// new input once its size has stopped changing, at most --concurrency at a
+// time. Each input is then moved to done/ (or failed/ when splitting it
+// failed), so a restarted daemon does not process it again.
+func runWatch(args []string) {
+	flags := pflag.NewFlagSet("watch", pflag.ExitOnError)
+	var (
+		dir          = flags.String("dir", "", "Ingest directory to watch (required)")
+		outputDir    = flags.String("output-dir", ".", "Output directory, created if missing")
+		patterns     = flags.StringSlice("pattern", chrsplit.DefaultInputPatterns, "File name patterns picked up in the ingest directory")
+		concurrency  = flags.Int("concurrency", 1, "Maximum number of inputs split at the same time")
+		pollInterval = flags.Duration("poll-interval", 5*time.Second, "How often the ingest directory is scanned")
+		chrFieldName = flags.String("chr-field-name", "chr", "Chromosome field name in JSON (a gjson path)")
+		chrNamesStr  = flags.StringP("chr-names", "c", "", "Custom chromosome names (comma-separated)")
+		normalize    = flags.Bool("normalize", false, "Match chromosomes ignoring a chr prefix and case")
+		workers      = flags.IntP("workers", "w", 1, "Number of goroutines extracting chromosomes per input")
+		gzipOutput   = flags.Bool("gzip", false, "Write gzip-compressed output files (.jsonl.gz)")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Split every new file landing in a directory, one output set per input\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s watch --dir DIR [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s watch --dir ingest/ --output-dir split/\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s watch --dir ingest/ --output-dir split/ --concurrency 2 --gzip\n", os.Args[0])
+	}
+	flags.Parse(args)
+
+	if *dir == "" {
+		fmt.Fprintf(os.Stderr, "Error: --dir is required\n\n")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if info, err := os.Stat(*dir); err != nil || !info.IsDir() {
+		log.Fatalf("Error: not a directory: %s", *dir)
+	}
+	if *concurrency < 1 {
+		log.Fatalf("Error: --concurrency must be at least 1")
+	}
+	// outputs written into the watched directory would be picked up as
+	// new inputs
+	if info, err := os.Stat(*outputDir); err == nil {
+		if dirInfo, _ := os.Stat(*dir); os.SameFile(info, dirInfo) {
+			log.Fatalf("Error: --output-dir must not be the watched directory %s", *dir)
+		}
+	}
+
+	cfg := watchConfig{
+		dir:       *dir,
+		patterns:  *patterns,
+		chrField:  *chrFieldName,
+		chrNames:  chrsplit.ParseChromosomeNames(*chrNamesStr),
+		outputDir: *outputDir,
+		opts: chrsplit.Options{
+			InputCompression:  chrsplit.CompressionAuto,
+			Gzip:              *gzipOutput,
+			Normalize:         *normalize,
+			MitoAliases:       chrsplit.DefaultMitoAliases,
+			Workers:           *workers,
+			DecompressThreads: 1,
+			OutputDir:         *outputDir,
+			// a file dropped in again under the same name replaces the
+			// outputs of its earlier version
+			Force: true,
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Watching %s for %v (every %s, %d at a time) -> %s", cfg.dir, cfg.patterns, *pollInterval, *concurrency, cfg.outputDir)
+
+	var (
+		mu   sync.Mutex
+		busy = make(map[string]bool)
+		// inputs with the same prefix, e.g. a.jsonl and a.jsonl.gz, write
+		// the same outputs, so they are split one after the other
+		busyPrefixes = make(map[string]bool)
+		sizes        = make(map[string]int64)
+		slots        = make(chan struct{}, *concurrency)
+		wg           sync.WaitGroup
+	)
+	for {
+		entries, err := os.ReadDir(cfg.dir)
+		if err != nil {
+			log.Printf("Error: failed to list %s: %v", cfg.dir, err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || !matchesPattern(cfg.patterns, name) {
+				continue
+			}
+			path := filepath.Join(cfg.dir, name)
+			prefix := ingestPrefix(name)
+			mu.Lock()
+			running := busy[path] || busyPrefixes[prefix]
+			mu.Unlock()
+			if running {
+				continue
+			}
+
+			// a file is only picked up once its size is the same on two
+			// polls, so an input still being copied in is not split half-way
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			if prev, seen := sizes[path]; !seen || prev != info.Size() {
+				sizes[path] = info.Size()
+				continue
+			}
+			delete(sizes, path)
+
+			mu.Lock()
+			busy[path], busyPrefixes[prefix] = true, true
+			mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(busy, path)
+					delete(busyPrefixes, prefix)
+					mu.Unlock()
+				}()
+
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					return // left in place for the next start
+				}
+				defer func() { <-slots }()
+				cfg.split(path)
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping, waiting for running splits to finish")
+			wg.Wait()
+			return
+		case <-time.After(*pollInterval):
+		}
+	}
+}
+
+// split splits one ingested input into its own output set, named after the
+// input, and moves the input to done/ or failed/. A failure is logged and
+// does not stop the daemon.
+func (cfg watchConfig) split(path string) {
+	startTime := time.Now()
+	prefix := ingestPrefix(filepath.Base(path))
+
+	processor := chrsplit.NewChromosomeProcessor([]string{path}, prefix, cfg.chrField, cfg.chrNames, cfg.opts)
+	err := processor.ProcessFile()
+
+	dest := watchDoneDir
+	if err != nil {
+		log.Printf("Error: failed to split %s: %v", path, err)
+		dest = watchFailedDir
+	} else {
+		lines := 0
+		for _, n := range processor.Stats() {
+			lines += n
+		}
+		log.Printf("Split %s: %d lines in %.2f s -> %s", path, lines, time.Since(startTime).Seconds(), processor.OutputPath("*"))
+	}
+
+	destDir := filepath.Join(cfg.dir, dest)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		log.Printf("Error: failed to create %s: %v", destDir, err)
+		return
+	}
+	if err := os.Rename(path, filepath.Join(destDir, filepath.Base(path))); err != nil {
+		log.Printf("Error: failed to move %s to %s: %v", path, destDir, err)
+	}
+}
+
+// ingestPrefix derives the output prefix of an ingested file from its name,
+// e.g. "sample1.jsonl.gz" -> "sample1"
+func ingestPrefix(name string) string {
+	for _, ext := range []string{".gz", ".zst", ".bz2", ".xz"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	for _, ext := range []string{".jsonl", ".ndjson", ".json"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// matchesPattern reports whether name matches one of the file name patterns
+func matchesPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}